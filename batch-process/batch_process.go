@@ -1,9 +1,13 @@
 package batchprocess
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -19,51 +23,180 @@ const (
 	__DefaultBatcherCloseTimeout = 5 * time.Second
 )
 
-// BatcherGroupCfg 匹处理器组配置
-type BatcherGroupCfg struct {
+var (
+	// ErrItemTooLarge 单个事务的大小超过了MaxBatchBytes
+	ErrItemTooLarge = errors.New("item is larger than max batch bytes")
+	// ErrQueueFull 批处理器的队列已满
+	ErrQueueFull = errors.New("batcher queue is full")
+	// ErrDropped 批处理器的队列已满, 事务被丢弃
+	ErrDropped = errors.New("batcher queue is full, item dropped")
+	// ErrClosed 批处理器已经关闭
+	ErrClosed = errors.New("batcher has been closed")
+)
+
+// OverflowMode 批处理器的队列已满时Put的处理策略
+type OverflowMode int
+
+const (
+	// OverflowBlock 阻塞等待队列有空闲, 直到超时
+	OverflowBlock OverflowMode = iota
+	// OverflowDropNewest 丢弃新加入的事务, 返回ErrDropped
+	OverflowDropNewest
+	// OverflowDropOldest 丢弃队列中最早的事务, 加入新的事务
+	OverflowDropOldest
+	// OverflowFailFast 立即返回ErrQueueFull
+	OverflowFailFast
+)
+
+// OversizePolicy 单个事务的大小超过MaxBatchBytes时的处理策略
+type OversizePolicy int
+
+const (
+	// OversizeReject Put直接返回ErrItemTooLarge
+	OversizeReject OversizePolicy = iota
+	// OversizeEmitAlone 将该事务单独作为一个批次交给DoBatch处理
+	OversizeEmitAlone
+)
+
+// TypedBatcherGroupCfg 匹处理器组配置, T为事务的类型
+type TypedBatcherGroupCfg[T any] struct {
 	BatcherNum         int
 	BatcherConcurrency int
 	MaxBatchSize       int
 	FlushTimeMs        int
 	SourceQueueSize    int
+	RetryPolicy        *RetryPolicy       // 为nil表示DoBatch失败后不重试
+	DeadLetter         TypedDeadLetter[T] // 为nil表示仅记录日志并丢弃失败的批次, 启用预写日志时失败的批次在重启后重新投递
+	MaxBatchBytes      int                // 批次中事务的总大小上限, 为0表示不限制
+	Weigher            func(T) int        // 计算单个事务的大小, MaxBatchBytes大于0时必须设置
+	OversizePolicy     OversizePolicy     // 单个事务的大小超过MaxBatchBytes时的处理策略
+	OverflowMode       OverflowMode       // 队列已满时Put的处理策略
+	Metrics            MetricsHook        // 为nil表示不上报指标, 仍然可以通过Stats获取统计快照
+	Ordered            bool               // 同一个槽位的批次按照FIFO顺序逐个处理, 不同槽位之间仍然并行
+	WALDir             string             // 预写日志的目录, 为空表示不启用预写日志
+	WALCodec           Codec[T]           // 事务的序列化方式, 启用预写日志时必须设置
+	WALSegmentSize     int                // 每个日志分段最多包含的记录数量
+	WALSync            bool               // 每写入或确认一条记录都刷到硬盘, 否则确认状态只在分段切换和关闭时写入
+}
+
+// BatcherGroupCfg 匹处理器组配置
+type BatcherGroupCfg = TypedBatcherGroupCfg[interface{}]
+
+// TypedSourceItem 待处理的事务单元
+type TypedSourceItem[T any] struct {
+	key    string
+	item   T
+	weight int
+	seq    uint64 // 预写日志中的序号
+	logged bool   // 是否已经写入预写日志
 }
 
 // SourceItem 待处理的事务单元
-type SourceItem struct {
-	key  string
-	item interface{}
+type SourceItem = TypedSourceItem[interface{}]
+
+// FlushReason 批次被交给DoBatch处理的触发原因
+type FlushReason int
+
+const (
+	// FlushBySize 批次中的事务数量达到MaxBatchSize
+	FlushBySize FlushReason = iota
+	// FlushByAge 批次中最早的事务等待时间达到FlushTimeMs
+	FlushByAge
+	// FlushByShutdown 批处理器关闭时排空剩余的批次
+	FlushByShutdown
+	// FlushByBytes 批次中事务的总大小达到MaxBatchBytes
+	FlushByBytes
+)
+
+func (r FlushReason) String() string {
+	switch r {
+	case FlushBySize:
+		return "size"
+	case FlushByAge:
+		return "age"
+	case FlushByShutdown:
+		return "shutdown"
+	case FlushByBytes:
+		return "bytes"
+	default:
+		return fmt.Sprintf("FlushReason(%d)", int(r))
+	}
 }
 
-// BatchItem 批处理队列中待处理的事务单元
-type BatchItem struct {
+// Batch 批处理队列中待处理的事务单元, Items仅包含已填充的事务
+type Batch[T any] struct {
 	CreatedTime time.Time
-	Items       []interface{}
-	NextItemIdx int
+	Items       []T
+	NextItemIdx int // 已填充的事务数量, 与len(Items)相等
+	Bytes       int // 已填充的事务的总大小, 仅在设置了Weigher时有效
+	Reason      FlushReason
+
+	seqs []uint64 // 事务在预写日志中的序号, 处理成功后确认
 }
 
+// BatchItem 批处理队列中待处理的事务单元
+type BatchItem = Batch[interface{}]
+
+// BatchFunc 匹处理器处理事务的处理函数, 可以获取批次的创建时间和触发原因
+type BatchFunc[T any] func(*Batch[T]) error
+
 // DoBatch 匹处理器处理事务的处理函数
-type DoBatch func(*BatchItem) error
+type DoBatch = BatchFunc[interface{}]
 
-// Batcher 匹处理器, 用于批量处理指定的事务
-type Batcher struct {
+// CloseStat 关闭批处理器时的排空结果
+type CloseStat struct {
+	Flushed int64 // 已交给DoBatch处理的事务数量
+	Lost    int64 // 截止时间到达时仍未交给DoBatch处理的事务数量
+}
+
+// TypedBatcher 匹处理器, 用于批量处理指定的事务
+type TypedBatcher[T any] struct {
 	mu sync.RWMutex
 
-	id                 int
-	cfg                *BatcherGroupCfg
-	sourceQ            chan SourceItem
-	batchQ             chan BatchItem
-	doBatch            DoBatch
-	flushInterval      time.Duration
-	expiredInterval    time.Duration
-	processedPerThread []int64
-	done               chan struct{}
+	id              int
+	cfg             *TypedBatcherGroupCfg[T]
+	concurrency     int  // 创建时的BatcherConcurrency, 之后不再变化
+	wal             *wal // 所属批处理器组的预写日志, 可以为nil
+	sourceQ         chan TypedSourceItem[T]
+	batchQs         []chan Batch[T] // 有序模式下每个槽位一个队列, 否则所有sink共享一个队列
+	doBatch         BatchFunc[T]
+	flushInterval   time.Duration // 事务在批次中的最长等待时间
+	expiredInterval time.Duration // 批次达到该时长后, 在下一次检查时被交给sink处理
+	stats           *batcherStats
+	enqueued        int64 // 已加入批处理器且未被丢弃的事务数量, 原子操作
+	closed          bool  // 受mu保护
+	started         bool  // 受mu保护
+	quit            chan struct{}
+	closeOnce       sync.Once
+
+	drainMu sync.Mutex
+	flushed int64 // 已交给DoBatch处理的事务数量, 受drainMu保护
+	aborted bool  // 关闭超时, 放弃处理剩余的事务, 受drainMu保护
+	abort   chan struct{}
+	done    chan struct{}
 }
 
-// BatcherGroup 一组匹处理器
-type BatcherGroup []*Batcher
+// Batcher 匹处理器, 用于批量处理指定的事务
+type Batcher = TypedBatcher[interface{}]
 
-// NewBatcherGroup 返回BatcherGroup实例.
-func NewBatcherGroup(cfg *BatcherGroupCfg) BatcherGroup {
+// TypedBatcherGroup 一组匹处理器, T为事务的类型.
+// 事务按照关键词的一致性哈希分发给批处理器, 运行期间可以调整批处理器的数量和并发度.
+type TypedBatcherGroup[T any] struct {
+	mu sync.RWMutex
+
+	cfg      *TypedBatcherGroupCfg[T]
+	batchers []*TypedBatcher[T] // 按编号有序
+	ring     *hashRing
+	doBatch  BatchFunc[T]
+	closed   bool
+	wal      *wal
+	replay   []walRecord // 启动时需要重新投递的记录
+	// stats 按编号保存的统计计数器, 相同编号的新实例继续累加, 被移除的批处理器的统计也不会丢失
+	stats map[int]*batcherStats
+}
+
+// NewTypedBatcherGroup 返回TypedBatcherGroup实例.
+func NewTypedBatcherGroup[T any](cfg *TypedBatcherGroupCfg[T]) *TypedBatcherGroup[T] {
 	if cfg == nil {
 		log.Error().Msg("no batcher")
 		return nil
@@ -84,42 +217,322 @@ func NewBatcherGroup(cfg *BatcherGroupCfg) BatcherGroup {
 	if cfg.SourceQueueSize == 0 {
 		cfg.SourceQueueSize = __DefaultSourceQueueSize
 	}
+	if cfg.RetryPolicy != nil {
+		cfg.RetryPolicy.setDefaults()
+	}
+	if cfg.MaxBatchBytes > 0 && cfg.Weigher == nil {
+		log.Error().Msg("no weigher for max batch bytes")
+		return nil
+	}
 
-	bg := make(BatcherGroup, cfg.BatcherNum)
+	bg := &TypedBatcherGroup[T]{
+		cfg:      cfg,
+		batchers: make([]*TypedBatcher[T], cfg.BatcherNum),
+		stats:    make(map[int]*batcherStats),
+	}
+	if cfg.WALDir != "" {
+		if cfg.WALCodec == nil {
+			log.Error().Msg("no codec for wal")
+			return nil
+		}
+		w, records, err := openWAL(cfg.WALDir, cfg.WALSegmentSize, cfg.WALSync)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to open wal %s", cfg.WALDir)
+			return nil
+		}
+		bg.wal = w
+		bg.replay = records
+	}
 	for i := 0; i < cfg.BatcherNum; i++ {
-		b := NewBatcher(i, cfg)
-		bg[i] = b
+		bg.batchers[i] = bg.newBatcher(i)
 	}
+	bg.resetRing()
 	return bg
 }
 
-// Start 开始运行所有的批处理器.
-func (bg BatcherGroup) Start(doBatch DoBatch) {
-	for _, b := range bg {
+// newBatcher 返回编号为id的批处理器, 沿用该编号之前的统计计数器, 调用方需要持有写锁.
+func (bg *TypedBatcherGroup[T]) newBatcher(id int) *TypedBatcher[T] {
+	b := NewTypedBatcher(id, bg.cfg)
+	b.wal = bg.wal
+	if stats, ok := bg.stats[id]; ok {
+		b.stats = stats
+	} else {
+		bg.stats[id] = b.stats
+	}
+	return b
+}
+
+// Start 开始运行所有的批处理器, doBatch仅接收批次中已填充的事务.
+func (bg *TypedBatcherGroup[T]) Start(doBatch func([]T) error) {
+	bg.StartBatch(func(batch *Batch[T]) error {
+		return doBatch(batch.Items)
+	})
+}
+
+// StartBatch 开始运行所有的批处理器, doBatch接收完整的批次.
+// 如果启用了预写日志, 上次未确认的事务会在返回前被重新投递.
+func (bg *TypedBatcherGroup[T]) StartBatch(doBatch BatchFunc[T]) {
+	bg.mu.Lock()
+	bg.doBatch = doBatch
+	for _, b := range bg.batchers {
 		b.Start(doBatch)
 	}
+	replay := bg.replay
+	bg.replay = nil
+	bg.mu.Unlock()
+
+	bg.replayWAL(replay)
 }
 
-// Close 停止运行所有的批处理器.
-func (bg BatcherGroup) Close() {
-	for _, b := range bg {
-		b.Close()
+// replayWAL 重新投递预写日志中未确认的事务, 无论OverflowMode如何都会阻塞等待.
+func (bg *TypedBatcherGroup[T]) replayWAL(records []walRecord) {
+	if len(records) > 0 {
+		log.Info().Msgf("replay %d items from wal %s", len(records), bg.cfg.WALDir)
+	}
+	for _, rec := range records {
+		job, err := bg.cfg.WALCodec.Unmarshal(rec.payload)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to decode wal record %d", rec.seq)
+			bg.wal.ack([]uint64{rec.seq})
+			continue
+		}
+		item := TypedSourceItem[T]{
+			key:    rec.key,
+			item:   job,
+			seq:    rec.seq,
+			logged: true,
+		}
+		err = bg.route(rec.key, func(b *TypedBatcher[T]) error {
+			return b.put(context.Background(), item, OverflowBlock, true /* wait */)
+		})
+		if err != nil {
+			// 未能投递的记录保留在预写日志中, 下次启动时再重新投递
+			log.Error().Err(err).Msgf("failed to replay wal record %d", rec.seq)
+		}
 	}
 }
 
+// Close 停止运行所有的批处理器, 最多等待__DefaultBatcherCloseTimeout.
+func (bg *TypedBatcherGroup[T]) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), __DefaultBatcherCloseTimeout)
+	defer cancel()
+
+	stat, err := bg.CloseCtx(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msgf("timeout to close batcher group, flushed %d, lost %d", stat.Flushed, stat.Lost)
+		return
+	}
+	log.Info().Msgf("batcher group has done, flushed %d", stat.Flushed)
+}
+
+// CloseCtx 并发地停止运行所有的批处理器, 并在ctx到期前将剩余的事务全部交给DoBatch处理.
+// 返回所有批处理器排空结果的汇总, 如果ctx到期, 同时返回ctx.Err().
+func (bg *TypedBatcherGroup[T]) CloseCtx(ctx context.Context) (CloseStat, error) {
+	bg.mu.Lock()
+	bg.closed = true
+	batchers := bg.batchers
+	bg.mu.Unlock()
+
+	stat, err := closeBatchers(ctx, batchers)
+	if bg.wal != nil {
+		if walErr := bg.wal.close(); walErr != nil && err == nil {
+			err = walErr
+		}
+	}
+	return stat, err
+}
+
+// closeBatchers 并发地关闭批处理器, 返回排空结果的汇总.
+func closeBatchers[T any](ctx context.Context, batchers []*TypedBatcher[T]) (CloseStat, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		total    CloseStat
+		firstErr error
+	)
+	for _, b := range batchers {
+		wg.Add(1)
+		go func(b *TypedBatcher[T]) {
+			defer wg.Done()
+			stat, err := b.CloseCtx(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			total.Flushed += stat.Flushed
+			total.Lost += stat.Lost
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(b)
+	}
+	wg.Wait()
+	return total, firstErr
+}
+
+// Resize 调整批处理器的数量.
+// 扩容时新的批处理器只接管一致性哈希环上相邻的关键词; 缩容时被移除的批处理器不再接收新的事务,
+// 并在ctx到期前排空剩余的事务, 返回其排空结果.
+// 迁移的关键词在迁移前后的事务之间不保证处理顺序.
+func (bg *TypedBatcherGroup[T]) Resize(ctx context.Context, batcherNum int) (CloseStat, error) {
+	if batcherNum <= 0 {
+		return CloseStat{}, fmt.Errorf("invalid batcher num %d", batcherNum)
+	}
+
+	bg.mu.Lock()
+	if bg.closed {
+		bg.mu.Unlock()
+		return CloseStat{}, ErrClosed
+	}
+	var removed []*TypedBatcher[T]
+	for len(bg.batchers) < batcherNum {
+		b := bg.newBatcher(bg.nextID())
+		if bg.doBatch != nil {
+			b.Start(bg.doBatch)
+		}
+		bg.batchers = append(bg.batchers, b)
+	}
+	if len(bg.batchers) > batcherNum {
+		// 优先移除编号最大的批处理器, 再次扩容时可以复用原来的编号和关键词分布
+		sort.Slice(bg.batchers, func(i, j int) bool {
+			return bg.batchers[i].id < bg.batchers[j].id
+		})
+		removed = bg.batchers[batcherNum:]
+		bg.batchers = bg.batchers[:batcherNum:batcherNum]
+	}
+	bg.cfg.BatcherNum = batcherNum
+	bg.resetRing()
+	bg.mu.Unlock()
+
+	return closeBatchers(ctx, removed)
+}
+
+// SetConcurrency 调整每个批处理器的并发度.
+// 每个批处理器被替换为相同编号的新实例, 关键词的分布保持不变.
+// 新实例在旧实例排空之后才开始处理批次, 因此有序模式下的顺序保证不受影响.
+func (bg *TypedBatcherGroup[T]) SetConcurrency(ctx context.Context, concurrency int) (CloseStat, error) {
+	if concurrency <= 0 {
+		return CloseStat{}, fmt.Errorf("invalid batcher concurrency %d", concurrency)
+	}
+
+	bg.mu.Lock()
+	if bg.closed {
+		bg.mu.Unlock()
+		return CloseStat{}, ErrClosed
+	}
+	bg.cfg.BatcherConcurrency = concurrency
+	removed := bg.batchers
+	bg.batchers = make([]*TypedBatcher[T], len(removed))
+	for i, old := range removed {
+		b := bg.newBatcher(old.id)
+		if bg.doBatch != nil {
+			b.startAfter(bg.doBatch, old.done)
+		}
+		bg.batchers[i] = b
+	}
+	bg.resetRing()
+	bg.mu.Unlock()
+
+	return closeBatchers(ctx, removed)
+}
+
+// Batchers 返回当前所有的批处理器.
+func (bg *TypedBatcherGroup[T]) Batchers() []*TypedBatcher[T] {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+	return append([]*TypedBatcher[T](nil), bg.batchers...)
+}
+
 // Put 将待处理的事务加入批处理器组, 并按照关键词分发给指定的批处理器处理.
-func (bg BatcherGroup) Put(key string, job interface{}) error {
-	return bg[FNV1av32(key)%uint32(len(bg))].Put(key, job)
+func (bg *TypedBatcherGroup[T]) Put(key string, job T) error {
+	return bg.route(key, func(b *TypedBatcher[T]) error {
+		return b.Put(key, job)
+	})
+}
+
+// PutCtx 同Put, 在OverflowBlock模式下最多等待到ctx到期.
+func (bg *TypedBatcherGroup[T]) PutCtx(ctx context.Context, key string, job T) error {
+	return bg.route(key, func(b *TypedBatcher[T]) error {
+		return b.PutCtx(ctx, key, job)
+	})
+}
+
+// TryPut 同Put, 但不会阻塞.
+func (bg *TypedBatcherGroup[T]) TryPut(key string, job T) error {
+	return bg.route(key, func(b *TypedBatcher[T]) error {
+		return b.TryPut(key, job)
+	})
+}
+
+// route 将关键词分发给负责的批处理器.
+// 如果该批处理器恰好因为Resize或SetConcurrency而被移除, 则按照新的哈希环重新分发;
+// 如果该批处理器是被直接关闭的, 返回ErrClosed.
+func (bg *TypedBatcherGroup[T]) route(key string, put func(*TypedBatcher[T]) error) error {
+	for {
+		bg.mu.RLock()
+		if bg.closed {
+			bg.mu.RUnlock()
+			return ErrClosed
+		}
+		ring := bg.ring
+		b := bg.pick(key)
+		bg.mu.RUnlock()
+
+		err := put(b)
+		if !errors.Is(err, ErrClosed) {
+			return err
+		}
+
+		// 每次调整都会重建哈希环
+		bg.mu.RLock()
+		resized := bg.ring != ring
+		bg.mu.RUnlock()
+		if !resized {
+			return err
+		}
+	}
+}
+
+// pick 返回负责关键词key的批处理器, 调用方需要持有读锁.
+func (bg *TypedBatcherGroup[T]) pick(key string) *TypedBatcher[T] {
+	id := bg.ring.get(key)
+	i := sort.Search(len(bg.batchers), func(i int) bool {
+		return bg.batchers[i].id >= id
+	})
+	return bg.batchers[i]
+}
+
+// nextID 返回最小的未被使用的批处理器编号, 调用方需要持有写锁.
+func (bg *TypedBatcherGroup[T]) nextID() int {
+	used := make(map[int]bool, len(bg.batchers))
+	for _, b := range bg.batchers {
+		used[b.id] = true
+	}
+	id := 0
+	for used[id] {
+		id++
+	}
+	return id
+}
+
+// resetRing 按照当前的批处理器重新生成哈希环, 调用方需要持有写锁.
+func (bg *TypedBatcherGroup[T]) resetRing() {
+	sort.Slice(bg.batchers, func(i, j int) bool {
+		return bg.batchers[i].id < bg.batchers[j].id
+	})
+	ids := make([]int, len(bg.batchers))
+	for i, b := range bg.batchers {
+		ids[i] = b.id
+	}
+	bg.ring = newHashRing(ids)
 }
 
 // Stat 显示所有批处理器的工作状态.
-func (bg BatcherGroup) Stat() {
+func (bg *TypedBatcherGroup[T]) Stat() {
 	var total int64
 	log.Info().Msgf("/******************** Stat ********************/")
-	for i, b := range bg {
-		t := b.Stat()
-		if t > 0 {
-			log.Info().Msgf("[batcher-%d] processed %d batched requests", i, t)
+	for _, stats := range bg.Stats() {
+		if t := stats.Processed; t > 0 {
+			log.Info().Msgf("[batcher-%d] processed %d batched requests, dropped %d, dead-lettered %d batches",
+				stats.ID, t, stats.Dropped, stats.DeadLettered)
 			total += t
 		}
 	}
@@ -129,63 +542,275 @@ func (bg BatcherGroup) Stat() {
 	log.Info().Msgf("/******************** Stat ********************/")
 }
 
+// Stats 返回所有批处理器的统计快照, 按编号有序.
+// 统计按编号累加, 不会因为SetConcurrency而清零; 被Resize移除的批处理器仍然返回其统计, 但QueueDepth为0.
+func (bg *TypedBatcherGroup[T]) Stats() []BatcherStats {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+
+	stats := make([]BatcherStats, 0, len(bg.stats))
+	current := make(map[int]bool, len(bg.batchers))
+	for _, b := range bg.batchers {
+		current[b.id] = true
+		stats = append(stats, b.Stats())
+	}
+	for id, s := range bg.stats {
+		if !current[id] {
+			snapshot := s.snapshot()
+			snapshot.ID = id
+			stats = append(stats, snapshot)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
+
+// BatcherGroup 一组匹处理器, 是TypedBatcherGroup[interface{}]的简单封装.
+// 与旧版本的[]*Batcher不同, 它不再是切片, 需要遍历批处理器时请使用Batchers().
+type BatcherGroup struct {
+	*TypedBatcherGroup[interface{}]
+}
+
+// NewBatcherGroup 返回BatcherGroup实例, cfg为nil时返回的实例中TypedBatcherGroup为nil.
+func NewBatcherGroup(cfg *BatcherGroupCfg) BatcherGroup {
+	return BatcherGroup{NewTypedBatcherGroup(cfg)}
+}
+
+// Start 开始运行所有的批处理器.
+func (bg BatcherGroup) Start(doBatch DoBatch) {
+	bg.StartBatch(doBatch)
+}
+
 // NewBatcher 返回Batcher实例.
 func NewBatcher(id int, cfg *BatcherGroupCfg) *Batcher {
-	return &Batcher{
-		id:                 id,
-		cfg:                cfg,
-		sourceQ:            make(chan SourceItem, cfg.SourceQueueSize),
-		batchQ:             make(chan BatchItem, cfg.BatcherConcurrency+1),
-		flushInterval:      time.Duration(cfg.FlushTimeMs) * time.Millisecond,
-		expiredInterval:    time.Duration(cfg.FlushTimeMs*9/10) * time.Millisecond,
-		processedPerThread: make([]int64, cfg.BatcherConcurrency),
-		done:               make(chan struct{}),
+	return NewTypedBatcher(id, cfg)
+}
+
+func newBatchQueues[T any](cfg *TypedBatcherGroupCfg[T]) []chan Batch[T] {
+	if !cfg.Ordered {
+		return []chan Batch[T]{make(chan Batch[T], cfg.BatcherConcurrency+1)}
+	}
+	// 槽位数量与sink协程数量相同, 每个槽位由唯一的sink协程按顺序处理
+	batchQs := make([]chan Batch[T], cfg.BatcherConcurrency)
+	for i := range batchQs {
+		batchQs[i] = make(chan Batch[T], 1)
+	}
+	return batchQs
+}
+
+// NewTypedBatcher 返回TypedBatcher实例.
+func NewTypedBatcher[T any](id int, cfg *TypedBatcherGroupCfg[T]) *TypedBatcher[T] {
+	return &TypedBatcher[T]{
+		id:              id,
+		cfg:             cfg,
+		concurrency:     cfg.BatcherConcurrency,
+		sourceQ:         make(chan TypedSourceItem[T], cfg.SourceQueueSize),
+		batchQs:         newBatchQueues[T](cfg),
+		flushInterval:   time.Duration(cfg.FlushTimeMs) * time.Millisecond,
+		expiredInterval: time.Duration(cfg.FlushTimeMs*9/10) * time.Millisecond,
+		stats:           newBatcherStats(cfg.MaxBatchSize),
+		quit:            make(chan struct{}),
+		abort:           make(chan struct{}),
+		done:            make(chan struct{}),
 	}
 }
 
 // Start 开始运行批处理器.
-func (b *Batcher) Start(doBatch DoBatch) {
+func (b *TypedBatcher[T]) Start(doBatch BatchFunc[T]) {
+	b.startAfter(doBatch, nil)
+}
+
+// startAfter 开始运行批处理器, 但是在after关闭之前不处理任何批次.
+func (b *TypedBatcher[T]) startAfter(doBatch BatchFunc[T], after <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || b.started {
+		return
+	}
+	b.started = true
+
 	log.Info().Msgf("start batcher-%d", b.id)
 	b.doBatch = doBatch
 	go b.source()
-	go b.sink()
+	go b.sink(after)
 }
 
-// Close 停止运行批处理器.
-func (b *Batcher) Close() {
-	if b.sourceQ != nil {
-		close(b.sourceQ)
-		b.sourceQ = nil
+// Close 停止运行批处理器, 最多等待__DefaultBatcherCloseTimeout.
+func (b *TypedBatcher[T]) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), __DefaultBatcherCloseTimeout)
+	defer cancel()
+
+	stat, err := b.CloseCtx(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msgf("timeout to close batcher-%d, flushed %d, lost %d", b.id, stat.Flushed, stat.Lost)
+		return
 	}
+	log.Info().Msgf("batcher-%d has done", b.id)
+}
+
+// CloseCtx 停止运行批处理器, 并在ctx到期前将剩余的事务全部交给DoBatch处理.
+// 如果ctx到期, 批处理器放弃处理剩余的事务, 返回已处理和丢失的事务数量以及ctx.Err().
+func (b *TypedBatcher[T]) CloseCtx(ctx context.Context) (CloseStat, error) {
+	b.closeOnce.Do(func() {
+		// 先唤醒阻塞中的Put, 再关闭sourceQ
+		close(b.quit)
+		b.mu.Lock()
+		b.closed = true
+		close(b.sourceQ)
+		if !b.started {
+			// 从未运行过的批处理器没有sink, 队列中的事务全部丢失
+			close(b.done)
+		}
+		b.mu.Unlock()
+	})
 
 	select {
 	case <-b.done:
-		log.Info().Msgf("batcher-%d has done", b.id)
-	case <-time.After(__DefaultBatcherCloseTimeout):
-		log.Warn().Msgf("timeout to close batcher-%d", b.id)
+		return b.closeStat(), nil
+	case <-ctx.Done():
 	}
+
+	b.drainMu.Lock()
+	defer b.drainMu.Unlock()
+	if !b.aborted {
+		b.aborted = true
+		close(b.abort)
+	}
+	return CloseStat{
+		Flushed: b.flushed,
+		Lost:    atomic.LoadInt64(&b.enqueued) - b.flushed,
+	}, ctx.Err()
+}
+
+func (b *TypedBatcher[T]) closeStat() CloseStat {
+	b.drainMu.Lock()
+	defer b.drainMu.Unlock()
+	return CloseStat{
+		Flushed: b.flushed,
+		Lost:    atomic.LoadInt64(&b.enqueued) - b.flushed,
+	}
+}
+
+// Put 将待处理的事务加入批处理器, 最多等待__DefaultBatcherPutTimeout.
+func (b *TypedBatcher[T]) Put(key string, job T) error {
+	ctx, cancel := context.WithTimeout(context.Background(), __DefaultBatcherPutTimeout)
+	defer cancel()
+
+	err := b.PutCtx(ctx, key, job)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Warn().Msgf("timeout to put item for batcher-%d", b.id)
+		return fmt.Errorf("timeout to put item for batcher-%d: %w", b.id, ErrQueueFull)
+	}
+	return err
+}
+
+// PutCtx 将待处理的事务加入批处理器, 队列已满时按照OverflowMode处理.
+// 在OverflowBlock模式下, 一直等待直到队列有空闲或ctx到期.
+func (b *TypedBatcher[T]) PutCtx(ctx context.Context, key string, job T) error {
+	return b.put(ctx, TypedSourceItem[T]{key: key, item: job}, b.cfg.OverflowMode, true /* wait */)
 }
 
-// Put 将待处理的事务加入批处理器.
-func (b *Batcher) Put(key string, job interface{}) error {
-	item := SourceItem{
-		key:  key,
-		item: job,
+// TryPut 将待处理的事务加入批处理器, 不会阻塞.
+// 在OverflowBlock模式下, 队列已满时返回ErrQueueFull.
+func (b *TypedBatcher[T]) TryPut(key string, job T) error {
+	return b.put(context.Background(), TypedSourceItem[T]{key: key, item: job}, b.cfg.OverflowMode, false /* wait */)
+}
+
+func (b *TypedBatcher[T]) put(ctx context.Context, item TypedSourceItem[T], mode OverflowMode, wait bool) (err error) {
+	if b.cfg.MaxBatchBytes > 0 {
+		item.weight = b.cfg.Weigher(item.item)
+		if item.weight > b.cfg.MaxBatchBytes && b.cfg.OversizePolicy == OversizeReject {
+			return ErrItemTooLarge
+		}
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	if b.wal != nil && !item.logged {
+		// 不能遮蔽具名返回值err, 否则defer无法确认被拒绝的事务
+		payload, mErr := b.cfg.WALCodec.Marshal(item.item)
+		if mErr != nil {
+			return mErr
+		}
+		if item.seq, err = b.wal.append(item.key, payload); err != nil {
+			return err
+		}
+		item.logged = true
+		defer func() {
+			// 未被接收的事务不需要重新投递
+			if err != nil {
+				b.wal.ack([]uint64{item.seq})
+			}
+		}()
 	}
+
+	// 先计数再入队, 保证enqueued不会小于flushed
+	b.addEnqueued(1)
 	select {
 	case b.sourceQ <- item:
-	case <-time.After(__DefaultBatcherPutTimeout):
-		log.Warn().Msgf("timeout to put item for batcher-%d", b.id)
-		return fmt.Errorf("timeout to put item for batcher-%d", b.id)
+		b.onEnqueue()
+		return nil
+	default:
+	}
+
+	switch {
+	case mode == OverflowDropNewest:
+		b.addEnqueued(-1)
+		b.onDrop()
+		return ErrDropped
+	case mode == OverflowDropOldest:
+		for {
+			select {
+			case b.sourceQ <- item:
+				b.onEnqueue()
+				return nil
+			default:
+			}
+			// 丢弃队首最早的事务, 腾出空间
+			select {
+			case dropped := <-b.sourceQ:
+				b.addEnqueued(-1)
+				b.onDrop()
+				if dropped.logged {
+					b.wal.ack([]uint64{dropped.seq})
+				}
+			default:
+			}
+		}
+	case mode == OverflowFailFast || !wait:
+		b.addEnqueued(-1)
+		return ErrQueueFull
+	}
+
+	select {
+	case b.sourceQ <- item:
+		b.onEnqueue()
+		return nil
+	case <-b.quit:
+		b.addEnqueued(-1)
+		return ErrClosed
+	case <-ctx.Done():
+		b.addEnqueued(-1)
+		return ctx.Err()
 	}
-	return nil
 }
 
-func (b *Batcher) source() {
-	ticker := time.NewTicker(b.flushInterval)
+func (b *TypedBatcher[T]) source() {
+	// 每隔(flushInterval - expiredInterval)检查一次批次的时长,
+	// 保证事务在批次中的等待时间不超过flushInterval
+	checkInterval := b.flushInterval - b.expiredInterval
+	if checkInterval <= 0 {
+		checkInterval = b.flushInterval
+	}
+	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 
-	batchTable := make(map[uint32]BatchItem)
+	batchTable := make(map[uint32]Batch[T])
 BATCHER_LOOP:
 	for {
 		select {
@@ -193,80 +818,208 @@ BATCHER_LOOP:
 			if !ok {
 				break BATCHER_LOOP
 			}
-			k := FNV1av32(item.key) % uint32(b.cfg.BatcherConcurrency)
+			k := FNV1av32(item.key) % uint32(b.concurrency)
 			if _, exist := batchTable[k]; !exist {
-				batchTable[k] = BatchItem{
-					CreatedTime: time.Now(),
-					Items:       make([]interface{}, b.cfg.MaxBatchSize),
-					NextItemIdx: 0,
-				}
+				batchTable[k] = b.newBatch()
 			}
 			b.appendItemWithFlushOp(batchTable, k, &item)
 		case <-ticker.C:
-			// b.flush(batchTable, false /* flush */)
+			b.flush(batchTable, false /* flush */)
 		}
 	}
 
-	// b.flush(batchTable, true /* flush */)
-	// TODO: 在退出前, 如何优雅得处理剩下的事务?
-	close(b.batchQ)
+	// 在退出前, 将剩下未满的批次全部交给sink处理, 除非关闭已经超时
+	b.flush(batchTable, true /* flush */)
+	for _, batchQ := range b.batchQs {
+		close(batchQ)
+	}
 }
 
-func (b *Batcher) appendItemWithFlushOp(batchTable map[uint32]BatchItem, key uint32, source *SourceItem) {
+func (b *TypedBatcher[T]) appendItemWithFlushOp(batchTable map[uint32]Batch[T], key uint32, source *TypedSourceItem[T]) {
 	batch := batchTable[key]
 
-	if batch.NextItemIdx >= b.cfg.MaxBatchSize {
-		b.batchQ <- batch
-		batch = BatchItem{
-			CreatedTime: time.Now(),
-			Items:       make([]interface{}, b.cfg.MaxBatchSize),
-			NextItemIdx: 0,
-		}
+	// 加入该事务会超过MaxBatchBytes, 先将当前批次交给sink处理
+	maxBytes := b.cfg.MaxBatchBytes
+	if maxBytes > 0 && batch.NextItemIdx > 0 && batch.Bytes+source.weight > maxBytes {
+		batch.Reason = FlushByBytes
+		b.emit(key, batch)
+		batch = b.newBatch()
 	}
-	batch.Items[batch.NextItemIdx] = source.item
+
+	batch.Items = append(batch.Items, source.item)
 	batch.NextItemIdx++
+	batch.Bytes += source.weight
+	if source.logged {
+		batch.seqs = append(batch.seqs, source.seq)
+	}
 
-	batchTable[key] = batch
+	// 批次已满, 立即交给sink处理, 不必等待下一个事务到来
+	if batch.NextItemIdx >= b.cfg.MaxBatchSize {
+		batch.Reason = FlushBySize
+	} else if maxBytes > 0 && batch.Bytes >= maxBytes {
+		batch.Reason = FlushByBytes
+	} else {
+		batchTable[key] = batch
+		return
+	}
+	b.emit(key, batch)
+	delete(batchTable, key)
 }
 
-func (b *Batcher) flush(batchTable map[uint32]BatchItem, flush bool) {
+func (b *TypedBatcher[T]) newBatch() Batch[T] {
+	return Batch[T]{
+		CreatedTime: time.Now(),
+		Items:       make([]T, 0, b.cfg.MaxBatchSize),
+		NextItemIdx: 0,
+	}
+}
+
+func (b *TypedBatcher[T]) flush(batchTable map[uint32]Batch[T], flush bool) {
 	now := time.Now()
 	for key, batch := range batchTable {
-		if len(batch.Items) > 0 && (flush || batch.CreatedTime.Add(b.expiredInterval).Before(now)) {
-			b.batchQ <- batch
+		if batch.NextItemIdx > 0 && (flush || !batch.CreatedTime.Add(b.expiredInterval).After(now)) {
+			batch.Reason = FlushByAge
+			if flush {
+				batch.Reason = FlushByShutdown
+			}
+			if !b.emit(key, batch) {
+				return
+			}
 			delete(batchTable, key)
 		}
 	}
 }
 
-func (b *Batcher) sink() {
+// emit 将槽位key的批次交给sink处理, 如果关闭已经超时则放弃并返回false.
+func (b *TypedBatcher[T]) emit(key uint32, batch Batch[T]) bool {
+	batchQ := b.batchQs[0]
+	if b.cfg.Ordered {
+		batchQ = b.batchQs[key]
+	}
+	select {
+	case batchQ <- batch:
+	case <-b.abort:
+		return false
+	}
+
+	atomic.AddInt64(&b.stats.flushed[batch.Reason], 1)
+	b.stats.batchSize.observe(int64(batch.NextItemIdx))
+	if b.cfg.Metrics != nil {
+		b.cfg.Metrics.OnFlush(b.id, batch.Reason, batch.NextItemIdx)
+	}
+	return true
+}
+
+func (b *TypedBatcher[T]) sink(after <-chan struct{}) {
+	if after != nil {
+		<-after
+	}
+
 	var wg sync.WaitGroup
-	for i := 0; i < b.cfg.BatcherConcurrency; i++ {
+	for i := 0; i < b.concurrency; i++ {
 		wg.Add(1)
-		go func(idx int) {
+		go func(batchQ chan Batch[T]) {
 			defer wg.Done()
-			for item := range b.batchQ {
-				if err := b.doBatch(&item); err != nil {
-					log.Error().Err(err).Msgf("batcher-%d does batch job failed", b.id)
+			for item := range batchQ {
+				item := item // DoBatch可能持有该批次的指针
+				n := int64(item.NextItemIdx)
+				b.drainMu.Lock()
+				aborted := b.aborted
+				if !aborted {
+					b.flushed += n
+				}
+				b.drainMu.Unlock()
+				if aborted {
+					// 关闭已经超时, 仅排空队列, 不再处理
+					continue
+				}
+				start := time.Now()
+				err := b.doBatchWithRetry(&item)
+				latency := time.Since(start)
+				b.stats.latencyUs.observe(latency.Microseconds())
+				if b.cfg.Metrics != nil {
+					b.cfg.Metrics.OnBatchDone(b.id, latency, err)
+				}
+				if err != nil {
+					b.deadLetter(&item, err)
+				} else {
+					atomic.AddInt64(&b.stats.processed, n)
+				}
+				// 处理成功或者已经交给死信处理, 不再需要重新投递;
+				// 没有死信处理的失败批次不确认, 重启后从预写日志重新投递
+				if b.wal != nil && (err == nil || b.cfg.DeadLetter != nil) {
+					b.wal.ack(item.seqs)
 				}
-				b.processedPerThread[idx] += int64(len(item.Items))
 			}
-		}(i)
+		}(b.batchQs[i%len(b.batchQs)])
 	}
 	wg.Wait()
 	close(b.done)
 }
 
-// Stat 返回批处理器已经处理的批次.
-func (b *Batcher) Stat() int64 {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+// doBatchWithRetry 按照重试策略处理批次, 返回最后一次处理的错误.
+func (b *TypedBatcher[T]) doBatchWithRetry(item *Batch[T]) error {
+	policy := b.cfg.RetryPolicy
+	for attempt := 1; ; attempt++ {
+		err := b.doBatch(item)
+		if err == nil {
+			return nil
+		}
+		atomic.AddInt64(&b.stats.failures, 1)
+		if !policy.shouldRetry(attempt, err) {
+			return err
+		}
+		log.Warn().Err(err).Msgf("batcher-%d does batch job failed, retry %d/%d", b.id, attempt, policy.MaxAttempts-1)
 
-	var total int64
-	for _, x := range b.processedPerThread {
-		total += x
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-b.abort:
+			// 关闭已经超时, 不再重试
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (b *TypedBatcher[T]) deadLetter(item *Batch[T], err error) {
+	log.Error().Err(err).Msgf("batcher-%d does batch job failed", b.id)
+	atomic.AddInt64(&b.stats.deadLettered, 1)
+	if b.cfg.DeadLetter != nil {
+		b.cfg.DeadLetter(item, err)
+	}
+}
+
+// Stat 返回批处理器已经成功处理的事务数量.
+func (b *TypedBatcher[T]) Stat() int64 {
+	return atomic.LoadInt64(&b.stats.processed)
+}
+
+// Stats 返回批处理器的统计快照.
+func (b *TypedBatcher[T]) Stats() BatcherStats {
+	stats := b.stats.snapshot()
+	stats.ID = b.id
+	stats.QueueDepth = len(b.sourceQ)
+	return stats
+}
+
+// addEnqueued 同时调整本实例用于排空结果的计数和统计计数.
+func (b *TypedBatcher[T]) addEnqueued(delta int64) {
+	atomic.AddInt64(&b.enqueued, delta)
+	atomic.AddInt64(&b.stats.enqueued, delta)
+}
+
+func (b *TypedBatcher[T]) onEnqueue() {
+	if b.cfg.Metrics != nil {
+		b.cfg.Metrics.OnEnqueue(b.id)
+	}
+}
+
+func (b *TypedBatcher[T]) onDrop() {
+	atomic.AddInt64(&b.stats.dropped, 1)
+	if b.cfg.Metrics != nil {
+		b.cfg.Metrics.OnDrop(b.id)
 	}
-	return total
 }
 
 // FNV1av32 哈希函数.
@@ -1,7 +1,11 @@
 package batchprocess
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -41,7 +45,471 @@ func TestBatcherGroup(t *testing.T) {
 	time.Sleep(time.Second * time.Duration(5))
 
 	bg.Stat()
-	bg.Close()
+	stat, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	assert.Equal(t, int64(1024), stat.Flushed)
+	assert.Equal(t, int64(0), stat.Lost)
 
-	// TODO: FIX ME!!! Totally processed batched requests is 1048!!!
+	var total int64
+	for _, b := range bg.Batchers() {
+		total += b.Stat()
+	}
+	assert.Equal(t, int64(1024), total)
+}
+
+func TestBatcherGroupDrainOnClose(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []interface{}
+	)
+	bg := NewBatcherGroup(&BatcherGroupCfg{
+		BatcherNum:   2,
+		MaxBatchSize: 1024,
+		FlushTimeMs:  60 * 1000,
+	})
+	bg.Start(func(item *BatchItem) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, item.Items[:item.NextItemIdx]...)
+		return nil
+	})
+
+	for i := 0; i < 100; i++ {
+		err := bg.Put(fmt.Sprintf("key-%06d", i), i)
+		assert.Empty(t, err)
+	}
+
+	stat, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	assert.Equal(t, int64(100), stat.Flushed)
+	assert.Equal(t, int64(0), stat.Lost)
+	assert.Equal(t, 100, len(got))
+
+	err = bg.Put("key-closed", "job-closed")
+	assert.True(t, errors.Is(err, ErrClosed))
+}
+
+func TestBatcherGroupCloseDeadline(t *testing.T) {
+	release := make(chan struct{})
+	bg := NewBatcherGroup(&BatcherGroupCfg{
+		BatcherNum:         1,
+		BatcherConcurrency: 1,
+		MaxBatchSize:       1,
+	})
+	bg.Start(func(item *BatchItem) error {
+		<-release
+		return nil
+	})
+
+	for i := 0; i < 8; i++ {
+		err := bg.Put(fmt.Sprintf("key-%06d", i), i)
+		assert.Empty(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	stat, err := bg.CloseCtx(ctx)
+	close(release)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int64(8), stat.Flushed+stat.Lost)
+	assert.True(t, stat.Lost > 0)
+}
+
+func TestBatcherGroupFlushReason(t *testing.T) {
+	flushed := make(chan *BatchItem, 16)
+	bg := NewBatcherGroup(&BatcherGroupCfg{
+		BatcherNum:         1,
+		BatcherConcurrency: 1,
+		MaxBatchSize:       4,
+		FlushTimeMs:        200,
+	})
+	bg.Start(func(item *BatchItem) error {
+		flushed <- item
+		return nil
+	})
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		err := bg.Put("key", i)
+		assert.Empty(t, err)
+	}
+
+	item := <-flushed
+	assert.Equal(t, FlushBySize, item.Reason)
+	assert.Equal(t, 4, item.NextItemIdx)
+
+	item = <-flushed
+	assert.Equal(t, FlushByAge, item.Reason)
+	assert.Equal(t, 2, item.NextItemIdx)
+	assert.True(t, time.Since(start) < 400*time.Millisecond)
+
+	err := bg.Put("key", 6)
+	assert.Empty(t, err)
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	item = <-flushed
+	assert.Equal(t, FlushByShutdown, item.Reason)
+	assert.Equal(t, 1, item.NextItemIdx)
+}
+
+func TestTypedBatcherGroup(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		sum   int
+		count int
+	)
+	bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{
+		BatcherNum:   2,
+		MaxBatchSize: 7,
+	})
+	bg.Start(func(items []int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.True(t, len(items) > 0 && len(items) <= 7)
+		for _, x := range items {
+			sum += x
+			count++
+		}
+		return nil
+	})
+
+	for i := 1; i <= 100; i++ {
+		err := bg.Put(fmt.Sprintf("key-%06d", i), i)
+		assert.Empty(t, err)
+	}
+
+	stat, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	assert.Equal(t, int64(100), stat.Flushed)
+	assert.Equal(t, 100, count)
+	assert.Equal(t, 5050, sum)
+}
+
+func TestBatcherGroupMaxBatchBytes(t *testing.T) {
+	newGroup := func(policy OversizePolicy) (*TypedBatcherGroup[string], chan *Batch[string]) {
+		flushed := make(chan *Batch[string], 16)
+		bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[string]{
+			BatcherNum:         1,
+			BatcherConcurrency: 1,
+			MaxBatchSize:       100,
+			FlushTimeMs:        60 * 1000,
+			MaxBatchBytes:      10,
+			Weigher:            func(s string) int { return len(s) },
+			OversizePolicy:     policy,
+		})
+		bg.StartBatch(func(batch *Batch[string]) error {
+			flushed <- batch
+			return nil
+		})
+		return bg, flushed
+	}
+
+	bg, flushed := newGroup(OversizeReject)
+	assert.Empty(t, bg.Put("key", "aaaa"))
+	assert.Empty(t, bg.Put("key", "bbbb"))
+	assert.Empty(t, bg.Put("key", "cccc"))
+	assert.Equal(t, ErrItemTooLarge, bg.Put("key", "dddddddddddd"))
+
+	batch := <-flushed
+	assert.Equal(t, FlushByBytes, batch.Reason)
+	assert.Equal(t, []string{"aaaa", "bbbb"}, batch.Items)
+	assert.Equal(t, 8, batch.Bytes)
+
+	assert.Empty(t, bg.Put("key", "ddddddd"))
+	batch = <-flushed
+	assert.Equal(t, FlushByBytes, batch.Reason)
+	assert.Equal(t, []string{"cccc"}, batch.Items)
+	_, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	batch = <-flushed
+	assert.Equal(t, FlushByShutdown, batch.Reason)
+	assert.Equal(t, []string{"ddddddd"}, batch.Items)
+
+	bg, flushed = newGroup(OversizeEmitAlone)
+	assert.Empty(t, bg.Put("key", "aaaa"))
+	assert.Empty(t, bg.Put("key", "bbbbbbbbbbbb"))
+	batch = <-flushed
+	assert.Equal(t, []string{"aaaa"}, batch.Items)
+	batch = <-flushed
+	assert.Equal(t, FlushByBytes, batch.Reason)
+	assert.Equal(t, []string{"bbbbbbbbbbbb"}, batch.Items)
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	assert.Nil(t, NewTypedBatcherGroup(&TypedBatcherGroupCfg[string]{MaxBatchBytes: 10}))
+}
+
+func TestBatcherGroupOverflowMode(t *testing.T) {
+	newGroup := func(mode OverflowMode) (*TypedBatcherGroup[int], chan struct{}, chan []int) {
+		release := make(chan struct{})
+		flushed := make(chan []int, 16)
+		bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{
+			BatcherNum:         1,
+			BatcherConcurrency: 1,
+			MaxBatchSize:       1,
+			SourceQueueSize:    2,
+			OverflowMode:       mode,
+		})
+		bg.Start(func(items []int) error {
+			<-release
+			flushed <- items
+			return nil
+		})
+		return bg, release, flushed
+	}
+	// fill 阻塞sink后填满sourceQ, 返回成功加入的事务数量
+	fill := func(bg *TypedBatcherGroup[int]) int {
+		n := 0
+		for i := 0; i < 16; i++ {
+			if bg.TryPut("key", i) == nil {
+				n++
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return n
+	}
+
+	bg, release, _ := newGroup(OverflowFailFast)
+	n := fill(bg)
+	assert.Equal(t, ErrQueueFull, bg.TryPut("key", 100))
+	assert.Equal(t, ErrQueueFull, bg.PutCtx(context.Background(), "key", 100))
+	close(release)
+	stat, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	assert.Equal(t, int64(n), stat.Flushed)
+
+	bg, release, _ = newGroup(OverflowBlock)
+	fill(bg)
+	assert.Equal(t, ErrQueueFull, bg.TryPut("key", 100))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, bg.PutCtx(ctx, "key", 100))
+	cancel()
+	close(release)
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	assert.Equal(t, ErrClosed, bg.PutCtx(context.Background(), "key", 100))
+
+	bg, release, _ = newGroup(OverflowDropNewest)
+	fill(bg)
+	assert.Equal(t, ErrDropped, bg.PutCtx(context.Background(), "key", 100))
+	close(release)
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	bg, release, flushed := newGroup(OverflowDropOldest)
+	n = fill(bg)
+	assert.Equal(t, 16, n)
+	close(release)
+	stat, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	close(flushed)
+	var got []int
+	for items := range flushed {
+		got = append(got, items...)
+	}
+	assert.Equal(t, int64(len(got)), stat.Flushed)
+	assert.Equal(t, int64(0), stat.Lost)
+	assert.Equal(t, 15, got[len(got)-1])
+}
+
+func TestBatcherGroupOrdered(t *testing.T) {
+	type job struct {
+		key string
+		seq int
+	}
+	var (
+		mu   sync.Mutex
+		seen = make(map[string][]int)
+	)
+	bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[job]{
+		BatcherNum:         2,
+		BatcherConcurrency: 4,
+		MaxBatchSize:       3,
+		FlushTimeMs:        10,
+		Ordered:            true,
+	})
+	bg.Start(func(items []job) error {
+		time.Sleep(time.Duration(len(items)) * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, j := range items {
+			seen[j.key] = append(seen[j.key], j.seq)
+		}
+		return nil
+	})
+
+	for seq := 0; seq < 50; seq++ {
+		for k := 0; k < 8; k++ {
+			key := fmt.Sprintf("key-%02d", k)
+			err := bg.Put(key, job{key: key, seq: seq})
+			assert.Empty(t, err)
+		}
+	}
+	_, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	assert.Equal(t, 8, len(seen))
+	for key, seqs := range seen {
+		assert.Equal(t, 50, len(seqs), key)
+		for i, seq := range seqs {
+			assert.Equal(t, i, seq, key)
+		}
+	}
+}
+
+func TestHashRing(t *testing.T) {
+	owners := func(r *hashRing) map[string]int {
+		m := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			key := fmt.Sprintf("key-%06d", i)
+			m[key] = r.get(key)
+		}
+		return m
+	}
+
+	before := owners(newHashRing([]int{0, 1, 2, 3}))
+	counts := make(map[int]int)
+	for _, id := range before {
+		counts[id]++
+	}
+	for id := 0; id < 4; id++ {
+		assert.True(t, counts[id] > 1500 && counts[id] < 3500, counts)
+	}
+
+	// 扩容时只有迁移到新批处理器的关键词发生变化
+	after := owners(newHashRing([]int{0, 1, 2, 3, 4}))
+	moved := 0
+	for key, id := range after {
+		if id != before[key] {
+			assert.Equal(t, 4, id)
+			moved++
+		}
+	}
+	assert.True(t, moved > 1000 && moved < 3000, moved)
+}
+
+func TestBatcherGroupResize(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = make(map[string][]int)
+	)
+	bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{
+		BatcherNum:         2,
+		BatcherConcurrency: 2,
+		MaxBatchSize:       4,
+		FlushTimeMs:        10,
+		Ordered:            true,
+	})
+	bg.StartBatch(func(batch *Batch[int]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, seq := range batch.Items {
+			key := fmt.Sprintf("key-%02d", seq%16)
+			seen[key] = append(seen[key], seq/16)
+		}
+		return nil
+	})
+
+	put := func(from, to int) {
+		for seq := from; seq < to; seq++ {
+			err := bg.Put(fmt.Sprintf("key-%02d", seq%16), seq)
+			assert.Empty(t, err)
+		}
+	}
+
+	put(0, 320)
+	_, err := bg.Resize(context.Background(), 4)
+	assert.Empty(t, err)
+	assert.Equal(t, 4, len(bg.Batchers()))
+	put(320, 640)
+
+	// 调整并发度时关键词分布不变, 且新实例在旧实例排空之后才开始处理
+	_, err = bg.SetConcurrency(context.Background(), 3)
+	assert.Empty(t, err)
+	put(640, 960)
+
+	stat, err := bg.Resize(context.Background(), 1)
+	assert.Empty(t, err)
+	assert.Equal(t, int64(0), stat.Lost)
+	assert.Equal(t, 1, len(bg.Batchers()))
+	assert.Equal(t, 0, bg.Batchers()[0].id)
+	put(960, 1280)
+
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	assert.Equal(t, ErrClosed, bg.Put("key", 0))
+	_, err = bg.Resize(context.Background(), 2)
+	assert.Equal(t, ErrClosed, err)
+
+	total := 0
+	for _, seqs := range seen {
+		total += len(seqs)
+	}
+	assert.Equal(t, 1280, total)
+	for key, seqs := range seen {
+		assert.Equal(t, 80, len(seqs), key)
+	}
+}
+
+func TestBatcherGroupResizeBeforeStart(t *testing.T) {
+	bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{
+		BatcherNum:      2,
+		MaxBatchSize:    4,
+		FlushTimeMs:     10,
+		SourceQueueSize: 16,
+	})
+	for i := 0; i < 8; i++ {
+		assert.Empty(t, bg.Put(fmt.Sprintf("key-%d", i), i))
+	}
+
+	// 从未运行过的批处理器被移除时立即返回, 其队列中的事务全部丢失
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stat, err := bg.SetConcurrency(ctx, 2)
+	assert.Empty(t, err)
+	assert.Equal(t, CloseStat{Lost: 8}, stat)
+	stat, err = bg.Resize(ctx, 1)
+	assert.Empty(t, err)
+	assert.Equal(t, CloseStat{}, stat)
+
+	var processed int64
+	bg.Start(func(items []int) error {
+		atomic.AddInt64(&processed, int64(len(items)))
+		return nil
+	})
+	for i := 0; i < 8; i++ {
+		assert.Empty(t, bg.Put(fmt.Sprintf("key-%d", i), i))
+	}
+	_, err = bg.CloseCtx(ctx)
+	assert.Empty(t, err)
+	assert.Equal(t, int64(8), atomic.LoadInt64(&processed))
+}
+
+func TestBatcherGroupBatcherClosedDirectly(t *testing.T) {
+	bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{
+		BatcherNum:   2,
+		MaxBatchSize: 4,
+		FlushTimeMs:  10,
+	})
+	bg.Start(func(items []int) error {
+		return nil
+	})
+	defer bg.Close()
+
+	b := bg.Batchers()[0]
+	b.Close()
+
+	// 被直接关闭的批处理器不会被重新分发, Put返回ErrClosed而不是一直重试
+	for i := 0; i < 16; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		bg.mu.RLock()
+		picked := bg.pick(key)
+		bg.mu.RUnlock()
+		if picked == b {
+			assert.Equal(t, ErrClosed, bg.Put(key, i))
+		} else {
+			assert.Empty(t, bg.Put(key, i))
+		}
+	}
 }
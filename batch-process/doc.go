@@ -0,0 +1,17 @@
+// Package batchprocess 提供按关键词分组的批处理器.
+//
+// TypedBatcherGroup[T]是主要的实现, 支持按条数/字节数切分批次、按时间刷新、重试与死信、
+// 背压、统计、按槽位有序处理、运行时扩缩容以及WAL持久化.
+// BatcherGroup是TypedBatcherGroup[interface{}]的简单封装, 保留了旧版本的
+// NewBatcherGroup/Start/Close/Put/Stat用法.
+//
+// 兼容性说明: BatcherGroup以前的定义是[]*Batcher, 现在是封装TypedBatcherGroup的结构体,
+// 因为批处理器的数量可以通过Resize在运行时调整. 原先对BatcherGroup做下标访问、len或range
+// 的代码需要改为使用Batchers()返回的快照:
+//
+//	for _, b := range bg.Batchers() {
+//		...
+//	}
+//
+// 原先通过bg == nil判断配置无效的代码需要改为判断bg.TypedBatcherGroup == nil.
+package batchprocess
@@ -0,0 +1,71 @@
+package batchprocess
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	__DefaultRetryInitialBackoffMs = 100
+	__DefaultRetryMaxBackoffMs     = 10 * 1000
+	__DefaultRetryMultiplier       = 2.0
+)
+
+// RetryPolicy DoBatch处理失败时的重试策略
+type RetryPolicy struct {
+	MaxAttempts      int              // 最多尝试的次数(包含第一次), 小于等于1表示不重试
+	InitialBackoffMs int              // 第一次重试前的退避时间
+	MaxBackoffMs     int              // 退避时间的上限
+	Multiplier       float64          // 退避时间的增长倍数
+	Jitter           float64          // 退避时间随机抖动的比例, 取值范围为[0, 1]
+	Retryable        func(error) bool // 判断错误是否可以重试, 为nil表示所有错误都可以重试
+}
+
+// TypedDeadLetter 死信处理函数, 接收重试后仍然处理失败的批次以及最后一次的错误.
+type TypedDeadLetter[T any] func(item *Batch[T], err error)
+
+// DeadLetter 死信处理函数, 接收重试后仍然处理失败的批次以及最后一次的错误.
+type DeadLetter = TypedDeadLetter[interface{}]
+
+func (p *RetryPolicy) setDefaults() {
+	if p.InitialBackoffMs <= 0 {
+		p.InitialBackoffMs = __DefaultRetryInitialBackoffMs
+	}
+	if p.MaxBackoffMs <= 0 {
+		p.MaxBackoffMs = __DefaultRetryMaxBackoffMs
+	}
+	if p.MaxBackoffMs < p.InitialBackoffMs {
+		p.MaxBackoffMs = p.InitialBackoffMs
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = __DefaultRetryMultiplier
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+}
+
+// shouldRetry 判断第attempt次尝试失败后是否还需要重试.
+func (p *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil && !p.Retryable(err) {
+		return false
+	}
+	return true
+}
+
+// backoff 返回第attempt次尝试失败后的退避时间, 按指数增长并带有随机抖动.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoffMs) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxBackoffMs) {
+		d = float64(p.MaxBackoffMs)
+	}
+	d -= d * p.Jitter * rand.Float64() // nolint
+	return time.Duration(d * float64(time.Millisecond))
+}
@@ -0,0 +1,112 @@
+package batchprocess
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errTransient = errors.New("transient error")
+	errFatal     = errors.New("fatal error")
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{
+		MaxAttempts:      5,
+		InitialBackoffMs: 10,
+		MaxBackoffMs:     50,
+	}
+	p.setDefaults()
+	assert.Equal(t, 10*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, p.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, p.backoff(4))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(2)
+		assert.True(t, d > 10*time.Millisecond && d <= 20*time.Millisecond)
+	}
+}
+
+func TestBatcherGroupRetry(t *testing.T) {
+	var (
+		calls       int32
+		deadLetters int32
+	)
+	bg := NewBatcherGroup(&BatcherGroupCfg{
+		BatcherNum:         1,
+		BatcherConcurrency: 1,
+		MaxBatchSize:       1,
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts:      3,
+			InitialBackoffMs: 1,
+		},
+		DeadLetter: func(item *BatchItem, err error) {
+			atomic.AddInt32(&deadLetters, 1)
+		},
+	})
+	bg.Start(func(item *BatchItem) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errTransient
+		}
+		return nil
+	})
+
+	err := bg.Put("key", "job")
+	assert.Empty(t, err)
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&deadLetters))
+	assert.Equal(t, int64(1), bg.Batchers()[0].Stat())
+}
+
+func TestBatcherGroupDeadLetter(t *testing.T) {
+	var (
+		calls       int32
+		deadLetters = make(chan error, 2)
+	)
+	bg := NewBatcherGroup(&BatcherGroupCfg{
+		BatcherNum:         1,
+		BatcherConcurrency: 1,
+		MaxBatchSize:       1,
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts:      3,
+			InitialBackoffMs: 1,
+			Retryable: func(err error) bool {
+				return !errors.Is(err, errFatal)
+			},
+		},
+		DeadLetter: func(item *BatchItem, err error) {
+			deadLetters <- err
+		},
+	})
+	bg.Start(func(item *BatchItem) error {
+		atomic.AddInt32(&calls, 1)
+		if item.Items[0] == "fatal" {
+			return errFatal
+		}
+		return errTransient
+	})
+
+	err := bg.Put("key", "fatal")
+	assert.Empty(t, err)
+	assert.Equal(t, errFatal, <-deadLetters)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	err = bg.Put("key", "transient")
+	assert.Empty(t, err)
+	assert.Equal(t, errTransient, <-deadLetters)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	assert.Equal(t, int64(0), bg.Batchers()[0].Stat())
+}
@@ -0,0 +1,72 @@
+package batchprocess
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	__DefaultRingReplicas = 128
+)
+
+// hashRing 一致性哈希环, 增删批处理器时只有少量关键词需要迁移.
+// hashRing是只读的, 批处理器组变化时重新生成.
+type hashRing struct {
+	points []uint32 // 有序的虚拟节点哈希值
+	owners []int    // 虚拟节点对应的批处理器编号
+}
+
+func newHashRing(ids []int) *hashRing {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+	points := make([]point, 0, len(ids)*__DefaultRingReplicas)
+	for _, id := range ids {
+		for i := 0; i < __DefaultRingReplicas; i++ {
+			points = append(points, point{
+				hash:  ringHash(fmt.Sprintf("batcher-%d#%d", id, i)),
+				owner: id,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	r := &hashRing{
+		points: make([]uint32, len(points)),
+		owners: make([]int, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// get 返回负责关键词key的批处理器编号.
+func (r *hashRing) get(key string) int {
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// ringHash 在FNV1av32的基础上做一次murmur3的finalizer, 使相似的关键词在环上分布得更均匀.
+func ringHash(key string) uint32 {
+	h := FNV1av32(key)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,139 @@
+package batchprocess
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// __DefaultLatencyBucketsMs DoBatch处理耗时直方图的桶上界, 单位为毫秒
+	__DefaultLatencyBucketsMs = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+)
+
+// MetricsHook 指标上报钩子, 用于对接Prometheus等指标采集系统.
+// 所有方法都会被多个协程并发调用, 需要保证线程安全且不能阻塞.
+type MetricsHook interface {
+	// OnEnqueue 事务成功加入批处理器
+	OnEnqueue(batcher int)
+	// OnDrop 事务因为队列已满被丢弃
+	OnDrop(batcher int)
+	// OnFlush 批次被交给sink处理
+	OnFlush(batcher int, reason FlushReason, size int)
+	// OnBatchDone 批次处理结束, err不为nil表示重试后仍然失败
+	OnBatchDone(batcher int, latency time.Duration, err error)
+}
+
+// HistogramSnapshot 直方图快照.
+// Counts[i]为落在(Bounds[i-1], Bounds[i]]中的样本数量, 最后一个元素为大于所有上界的样本数量.
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []int64
+	Count  int64
+	Sum    float64
+}
+
+// BatcherStats 批处理器的统计快照
+type BatcherStats struct {
+	ID           int
+	Enqueued     int64                 // 已加入批处理器且未被丢弃的事务数量
+	Dropped      int64                 // 因为队列已满被丢弃的事务数量
+	Processed    int64                 // 成功处理的事务数量
+	Flushed      map[FlushReason]int64 // 按触发原因统计的批次数量
+	Failures     int64                 // DoBatch返回错误的次数, 包括重试
+	DeadLettered int64                 // 重试后仍然处理失败的批次数量
+	QueueDepth   int                   // sourceQ中等待的事务数量
+	BatchSize    HistogramSnapshot     // 批次中事务数量的分布
+	LatencyMs    HistogramSnapshot     // DoBatch处理耗时的分布(包括重试), 单位为毫秒
+}
+
+// histogram 固定桶的直方图, 线程安全
+type histogram struct {
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    int64 // 按整数累加, 避免浮点数的原子操作
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// newBatchSizeHistogram 返回以2的幂次为桶上界的直方图, 最大的上界为maxBatchSize.
+func newBatchSizeHistogram(maxBatchSize int) *histogram {
+	var bounds []float64
+	for x := 1; x < maxBatchSize; x <<= 1 {
+		bounds = append(bounds, float64(x))
+	}
+	bounds = append(bounds, float64(maxBatchSize))
+	return newHistogram(bounds)
+}
+
+func (h *histogram) observe(v int64) {
+	i := 0
+	for i < len(h.bounds) && float64(v) > h.bounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, v)
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Bounds: append([]float64(nil), h.bounds...),
+		Counts: make([]int64, len(h.counts)),
+		Count:  atomic.LoadInt64(&h.count),
+		Sum:    float64(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return s
+}
+
+// batcherStats 批处理器的统计计数器, 线程安全
+type batcherStats struct {
+	enqueued     int64
+	dropped      int64
+	processed    int64
+	flushed      [FlushByBytes + 1]int64
+	failures     int64
+	deadLettered int64
+	batchSize    *histogram
+	latencyUs    *histogram // 按微秒累加, 快照时转换为毫秒
+}
+
+func newBatcherStats(maxBatchSize int) *batcherStats {
+	boundsUs := make([]float64, len(__DefaultLatencyBucketsMs))
+	for i, ms := range __DefaultLatencyBucketsMs {
+		boundsUs[i] = ms * 1000
+	}
+	return &batcherStats{
+		batchSize: newBatchSizeHistogram(maxBatchSize),
+		latencyUs: newHistogram(boundsUs),
+	}
+}
+
+func (s *batcherStats) snapshot() BatcherStats {
+	stats := BatcherStats{
+		Enqueued:     atomic.LoadInt64(&s.enqueued),
+		Dropped:      atomic.LoadInt64(&s.dropped),
+		Processed:    atomic.LoadInt64(&s.processed),
+		Flushed:      make(map[FlushReason]int64, len(s.flushed)),
+		Failures:     atomic.LoadInt64(&s.failures),
+		DeadLettered: atomic.LoadInt64(&s.deadLettered),
+		BatchSize:    s.batchSize.snapshot(),
+		LatencyMs:    s.latencyUs.snapshot(),
+	}
+	for reason := range s.flushed {
+		stats.Flushed[FlushReason(reason)] = atomic.LoadInt64(&s.flushed[reason])
+	}
+	for i := range stats.LatencyMs.Bounds {
+		stats.LatencyMs.Bounds[i] /= 1000
+	}
+	stats.LatencyMs.Sum /= 1000
+	return stats
+}
@@ -0,0 +1,155 @@
+package batchprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type counterHook struct {
+	enqueued int64
+	dropped  int64
+	flushed  int64
+	done     int64
+	failed   int64
+}
+
+func (h *counterHook) OnEnqueue(batcher int) {
+	atomic.AddInt64(&h.enqueued, 1)
+}
+
+func (h *counterHook) OnDrop(batcher int) {
+	atomic.AddInt64(&h.dropped, 1)
+}
+
+func (h *counterHook) OnFlush(batcher int, reason FlushReason, size int) {
+	atomic.AddInt64(&h.flushed, int64(size))
+}
+
+func (h *counterHook) OnBatchDone(batcher int, latency time.Duration, err error) {
+	atomic.AddInt64(&h.done, 1)
+	if err != nil {
+		atomic.AddInt64(&h.failed, 1)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newBatchSizeHistogram(10)
+	assert.Equal(t, []float64{1, 2, 4, 8, 10}, h.bounds)
+	for _, v := range []int64{1, 2, 3, 4, 9, 10, 11} {
+		h.observe(v)
+	}
+	s := h.snapshot()
+	assert.Equal(t, []int64{1, 1, 2, 0, 2, 1}, s.Counts)
+	assert.Equal(t, int64(7), s.Count)
+	assert.Equal(t, float64(40), s.Sum)
+}
+
+func TestBatcherGroupStats(t *testing.T) {
+	hook := &counterHook{}
+	bg := NewBatcherGroup(&BatcherGroupCfg{
+		BatcherNum:         2,
+		BatcherConcurrency: 2,
+		MaxBatchSize:       8,
+		FlushTimeMs:        60 * 1000,
+		Metrics:            hook,
+	})
+	bg.Start(func(item *BatchItem) error {
+		for _, x := range item.Items {
+			if x == "fail" {
+				return errors.New("failed")
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < 64; i++ {
+		err := bg.Put(fmt.Sprintf("key-%06d", i), i)
+		assert.Empty(t, err)
+	}
+	err := bg.Put("key-fail", "fail")
+	assert.Empty(t, err)
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	var (
+		enqueued, processed, batches, failures, deadLettered int64
+		sizes                                                int64
+	)
+	for i, stats := range bg.Stats() {
+		assert.Equal(t, i, stats.ID)
+		assert.Equal(t, 0, stats.QueueDepth)
+		enqueued += stats.Enqueued
+		processed += stats.Processed
+		failures += stats.Failures
+		deadLettered += stats.DeadLettered
+		for _, n := range stats.Flushed {
+			batches += n
+		}
+		sizes += int64(stats.BatchSize.Sum)
+		assert.Equal(t, stats.BatchSize.Count, stats.LatencyMs.Count)
+	}
+	assert.Equal(t, int64(65), enqueued)
+	assert.Equal(t, int64(65), sizes)
+	assert.True(t, processed >= 57 && processed < 65)
+	assert.Equal(t, int64(1), failures)
+	assert.Equal(t, int64(1), deadLettered)
+
+	assert.Equal(t, int64(65), atomic.LoadInt64(&hook.enqueued))
+	assert.Equal(t, int64(65), atomic.LoadInt64(&hook.flushed))
+	assert.Equal(t, batches, atomic.LoadInt64(&hook.done))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&hook.failed))
+}
+
+func TestBatcherGroupStatsAfterResize(t *testing.T) {
+	bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{
+		BatcherNum:         2,
+		BatcherConcurrency: 2,
+		MaxBatchSize:       4,
+		FlushTimeMs:        10,
+	})
+	bg.Start(func(items []int) error {
+		return nil
+	})
+	total := func() (enqueued, processed int64) {
+		for _, stats := range bg.Stats() {
+			enqueued += stats.Enqueued
+			processed += stats.Processed
+		}
+		return enqueued, processed
+	}
+	put := func(n int) {
+		for i := 0; i < n; i++ {
+			assert.Empty(t, bg.Put(fmt.Sprintf("key-%06d", i), i))
+		}
+	}
+
+	// 替换后的批处理器继续累加原来的统计
+	put(32)
+	_, err := bg.SetConcurrency(context.Background(), 4)
+	assert.Empty(t, err)
+	enqueued, processed := total()
+	assert.Equal(t, int64(32), enqueued)
+	assert.Equal(t, int64(32), processed)
+
+	// 被移除的批处理器的统计仍然保留
+	_, err = bg.Resize(context.Background(), 1)
+	assert.Empty(t, err)
+	assert.Equal(t, 1, len(bg.Batchers()))
+	stats := bg.Stats()
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, 0, stats[0].ID)
+	assert.Equal(t, 1, stats[1].ID)
+
+	put(16)
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+	enqueued, processed = total()
+	assert.Equal(t, int64(48), enqueued)
+	assert.Equal(t, int64(48), processed)
+}
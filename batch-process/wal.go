@@ -0,0 +1,429 @@
+package batchprocess
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/usherasnick/Useful-Go-Gadgets/fwriter"
+)
+
+const (
+	__DefaultWALSegmentSize = 1024
+
+	__WALSegmentSuffix = ".wal"
+	__WALAckSuffix     = ".ack"
+	__WALRecordHeader  = 8 // len(uint32) + crc(uint32)
+)
+
+var (
+	errWALCorrupted = errors.New("wal record corrupted")
+)
+
+// Codec 事务的序列化方式, 用于将事务写入预写日志
+type Codec[T any] interface {
+	Marshal(T) ([]byte, error)
+	Unmarshal([]byte) (T, error)
+}
+
+// walRecord 预写日志中的一条记录
+type walRecord struct {
+	seq     uint64
+	key     string
+	payload []byte
+}
+
+// walSegment 一个日志分段的确认状态, 每个分段最多包含segSize条记录
+type walSegment struct {
+	acked   []byte // 已确认记录的位图
+	pending int    // 已写入但未确认的记录数量
+	sealed  bool   // 已经写满并提交, 不会再有新的记录
+	dirty   bool   // 位图有尚未写入硬盘的确认
+}
+
+// wal 预写日志.
+// 被接收的事务先写入当前的分段, 批次处理成功(或者交给死信处理)之后再确认.
+// 分段使用fwriter.SafeWriter写入临时文件, 写满后原子地重命名; 确认状态的位图也使用SafeWriter原子地覆盖.
+// 开启sync时每次确认都会立即写入位图, 否则只在分段切换和关闭时批量写入.
+// 重启时, 未确认(或者确认尚未写入)的记录会被重新投递, 因此事务至少被处理一次.
+type wal struct {
+	mu sync.Mutex
+
+	dir       string
+	segSize   uint64
+	sync      bool
+	nextSeq   uint64
+	active    *fwriter.SafeWriter
+	activeIdx uint64
+	segs      map[uint64]*walSegment
+	buf       []byte
+}
+
+// openWAL 打开目录dir下的预写日志, 返回其中所有未确认的记录.
+func openWAL(dir string, segSize int, sync bool) (*wal, []walRecord, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, nil, err
+	}
+	if segSize <= 0 {
+		segSize = __DefaultWALSegmentSize
+	}
+	w := &wal{
+		dir:     dir,
+		segSize: uint64(segSize),
+		sync:    sync,
+		segs:    make(map[uint64]*walSegment),
+	}
+	records, err := w.recover()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w, records, nil
+}
+
+func (w *wal) segmentFile(idx uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", idx, __WALSegmentSuffix))
+}
+
+func (w *wal) ackFile(idx uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", idx, __WALAckSuffix))
+}
+
+// recover 读取所有分段, 提交上次未写满的分段, 并清理SafeWriter遗留的临时文件.
+func (w *wal) recover() ([]walRecord, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		records []walRecord
+		maxIdx  uint64
+		found   bool
+	)
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(w.dir, name)
+		switch {
+		case strings.HasSuffix(name, ".lock"), strings.Contains(name, __WALAckSuffix+".tmp"):
+			os.Remove(path) // nolint
+			continue
+		case !strings.Contains(name, __WALSegmentSuffix):
+			continue
+		}
+
+		idx, err := strconv.ParseUint(name[:strings.Index(name, __WALSegmentSuffix)], 10, 64)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(name, __WALSegmentSuffix+".tmp") {
+			// 上次未写满的分段, 直接提交
+			if err := os.Rename(path, w.segmentFile(idx)); err != nil {
+				return nil, err
+			}
+		}
+		if !found || idx > maxIdx {
+			maxIdx = idx
+		}
+		found = true
+
+		segRecords, err := w.loadSegment(idx)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, segRecords...)
+	}
+
+	if found {
+		w.nextSeq = (maxIdx + 1) * w.segSize
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+	return records, nil
+}
+
+// loadSegment 读取已提交的分段idx, 返回其中未确认的记录.
+func (w *wal) loadSegment(idx uint64) ([]walRecord, error) {
+	f, err := os.Open(w.segmentFile(idx))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint
+
+	seg := &walSegment{
+		acked:  w.loadAcks(idx),
+		sealed: true,
+	}
+	var records []walRecord
+	r := bufio.NewReader(f)
+	for {
+		rec, err := readWALRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// 最后一条记录可能没有写完整, 丢弃之后的内容
+			log.Warn().Err(err).Msgf("truncated wal segment %d", idx)
+			break
+		}
+		off := rec.seq - idx*w.segSize
+		if rec.seq < idx*w.segSize || off >= w.segSize {
+			log.Warn().Msgf("wal record %d does not belong to segment %d", rec.seq, idx)
+			break
+		}
+		if seg.acked[off/8]&(1<<(off%8)) == 0 {
+			seg.pending++
+			records = append(records, rec)
+		}
+	}
+
+	if seg.pending == 0 {
+		w.removeSegment(idx)
+		return nil, nil
+	}
+	w.segs[idx] = seg
+	return records, nil
+}
+
+// loadAcks 读取分段idx的确认位图, 文件不存在或者损坏时返回空位图.
+func (w *wal) loadAcks(idx uint64) []byte {
+	acked := make([]byte, (w.segSize+7)/8)
+	raw, err := os.ReadFile(w.ackFile(idx))
+	if err != nil {
+		return acked
+	}
+	if len(raw) != 4+len(acked) || binary.LittleEndian.Uint32(raw) != crc32.ChecksumIEEE(raw[4:]) {
+		// 位图损坏时当作未确认处理, 最多导致重复投递
+		log.Warn().Msgf("corrupted wal ack file of segment %d", idx)
+		return acked
+	}
+	copy(acked, raw[4:])
+	return acked
+}
+
+// append 将事务写入当前分段, 返回记录的序号.
+func (w *wal) append(key string, payload []byte) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	idx := w.nextSeq / w.segSize
+	if w.active == nil || idx != w.activeIdx {
+		if err := w.roll(idx); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := w.nextSeq
+	w.buf = encodeWALRecord(w.buf[:0], seq, key, payload)
+	if _, err := w.active.Write(w.buf); err != nil {
+		return 0, err
+	}
+	if w.sync {
+		if err := w.active.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	w.nextSeq++
+	w.segs[idx].pending++
+	return seq, nil
+}
+
+// roll 提交当前分段并写入尚未写入的确认位图, 然后开始写入分段idx.
+func (w *wal) roll(idx uint64) error {
+	if err := w.seal(); err != nil {
+		return err
+	}
+	w.flushAcks() // nolint
+	active, err := fwriter.NewSafeWriter(w.segmentFile(idx))
+	if err != nil {
+		return err
+	}
+	w.active = active
+	w.activeIdx = idx
+	w.segs[idx] = &walSegment{
+		acked: make([]byte, (w.segSize+7)/8),
+	}
+	return nil
+}
+
+// seal 提交当前分段, 如果其中的记录已经全部确认则直接删除.
+func (w *wal) seal() error {
+	if w.active == nil {
+		return nil
+	}
+	err := w.active.Commit()
+	w.active = nil
+	if err != nil {
+		return err
+	}
+	seg := w.segs[w.activeIdx]
+	seg.sealed = true
+	if seg.pending == 0 {
+		w.removeSegment(w.activeIdx)
+	}
+	return nil
+}
+
+// flushAcks 写入所有尚未写入的确认位图, 返回遇到的第一个错误.
+func (w *wal) flushAcks() error {
+	var firstErr error
+	for idx, seg := range w.segs {
+		if !seg.dirty {
+			continue
+		}
+		if err := w.saveAcks(idx, seg.acked); err != nil {
+			log.Error().Err(err).Msgf("failed to save wal ack file of segment %d", idx)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		seg.dirty = false
+	}
+	return firstErr
+}
+
+// ack 确认序号为seqs的记录, 只有开启sync时才会立即写入确认位图.
+func (w *wal) ack(seqs []uint64) {
+	if len(seqs) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	touched := make(map[uint64]bool)
+	for _, seq := range seqs {
+		idx := seq / w.segSize
+		seg, ok := w.segs[idx]
+		if !ok {
+			continue
+		}
+		off := seq - idx*w.segSize
+		if seg.acked[off/8]&(1<<(off%8)) != 0 {
+			continue
+		}
+		seg.acked[off/8] |= 1 << (off % 8)
+		seg.pending--
+		seg.dirty = true
+		touched[idx] = true
+	}
+
+	for idx := range touched {
+		seg := w.segs[idx]
+		if seg.sealed && seg.pending == 0 {
+			w.removeSegment(idx)
+			continue
+		}
+		if !w.sync {
+			continue
+		}
+		if err := w.saveAcks(idx, seg.acked); err != nil {
+			log.Error().Err(err).Msgf("failed to save wal ack file of segment %d", idx)
+			continue
+		}
+		seg.dirty = false
+	}
+}
+
+func (w *wal) saveAcks(idx uint64, acked []byte) error {
+	sw, err := fwriter.NewSafeWriter(w.ackFile(idx))
+	if err != nil {
+		return err
+	}
+	raw := make([]byte, 4+len(acked))
+	binary.LittleEndian.PutUint32(raw, crc32.ChecksumIEEE(acked))
+	copy(raw[4:], acked)
+	if _, err := sw.Write(raw); err != nil {
+		sw.Abort()
+		return err
+	}
+	return sw.Commit()
+}
+
+func (w *wal) removeSegment(idx uint64) {
+	delete(w.segs, idx)
+	os.Remove(w.segmentFile(idx)) // nolint
+	os.Remove(w.ackFile(idx))     // nolint
+}
+
+// close 提交当前分段并写入所有尚未写入的确认位图, 之后的append会写入新的分段.
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.active != nil {
+		w.nextSeq = (w.activeIdx + 1) * w.segSize
+		if err := w.seal(); err != nil {
+			return err
+		}
+	}
+	return w.flushAcks()
+}
+
+// encodeWALRecord 将记录追加到buf, 记录的格式为
+//
+//	| len(uint32) | crc(uint32) | seq(uint64) | keyLen(uvarint) | key | payload |
+//
+// len和crc均针对seq之后的内容.
+func encodeWALRecord(buf []byte, seq uint64, key string, payload []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(len(key)))
+	bodyLen := 8 + n + len(key) + len(payload)
+
+	buf = append(buf, make([]byte, __WALRecordHeader+bodyLen)...)
+	body := buf[len(buf)-bodyLen:]
+	binary.LittleEndian.PutUint64(body, seq)
+	copy(body[8:], tmp[:n])
+	copy(body[8+n:], key)
+	copy(body[8+n+len(key):], payload)
+
+	header := buf[len(buf)-bodyLen-__WALRecordHeader:]
+	binary.LittleEndian.PutUint32(header, uint32(bodyLen))
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+func readWALRecord(r io.Reader) (walRecord, error) {
+	var header [__WALRecordHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return walRecord{}, errWALCorrupted
+		}
+		return walRecord{}, err
+	}
+	bodyLen := binary.LittleEndian.Uint32(header[:])
+	if bodyLen < 9 {
+		return walRecord{}, errWALCorrupted
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return walRecord{}, errWALCorrupted
+	}
+	if binary.LittleEndian.Uint32(header[4:]) != crc32.ChecksumIEEE(body) {
+		return walRecord{}, errWALCorrupted
+	}
+
+	seq := binary.LittleEndian.Uint64(body)
+	keyLen, n := binary.Uvarint(body[8:])
+	if n <= 0 || uint64(8+n)+keyLen > uint64(bodyLen) {
+		return walRecord{}, errWALCorrupted
+	}
+	keyEnd := 8 + n + int(keyLen)
+	return walRecord{
+		seq:     seq,
+		key:     string(body[8+n : keyEnd]),
+		payload: body[keyEnd:],
+	}, nil
+}
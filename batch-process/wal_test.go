@@ -0,0 +1,253 @@
+package batchprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type intCodec struct{}
+
+func (intCodec) Marshal(x int) ([]byte, error) {
+	raw := make([]byte, 8)
+	binary.LittleEndian.PutUint64(raw, uint64(x))
+	return raw, nil
+}
+
+func (intCodec) Unmarshal(raw []byte) (int, error) {
+	return int(binary.LittleEndian.Uint64(raw)), nil
+}
+
+func TestWALRecord(t *testing.T) {
+	var buf []byte
+	buf = encodeWALRecord(buf, 7, "key-7", []byte("job-7"))
+	buf = encodeWALRecord(buf, 8, "", nil)
+
+	r := bytes.NewReader(buf)
+	rec, err := readWALRecord(r)
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(7), rec.seq)
+	assert.Equal(t, "key-7", rec.key)
+	assert.Equal(t, []byte("job-7"), rec.payload)
+	rec, err = readWALRecord(r)
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(8), rec.seq)
+	assert.Equal(t, "", rec.key)
+
+	buf[len(buf)-1] ^= 0xff
+	r = bytes.NewReader(buf)
+	_, err = readWALRecord(r)
+	assert.Empty(t, err)
+	_, err = readWALRecord(r)
+	assert.Equal(t, errWALCorrupted, err)
+
+	_, err = readWALRecord(bytes.NewReader(buf[:5]))
+	assert.Equal(t, errWALCorrupted, err)
+}
+
+func walFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.Empty(t, err)
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	return files
+}
+
+func TestBatcherGroupWALAck(t *testing.T) {
+	dir := t.TempDir()
+	bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{
+		BatcherNum:     2,
+		MaxBatchSize:   3,
+		FlushTimeMs:    10,
+		WALDir:         dir,
+		WALCodec:       intCodec{},
+		WALSegmentSize: 4,
+	})
+	bg.Start(func(items []int) error {
+		return nil
+	})
+	for i := 0; i < 20; i++ {
+		assert.Empty(t, bg.Put("key", i))
+	}
+	_, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	// 所有的事务都已经确认, 分段被全部清理
+	assert.Empty(t, walFiles(t, dir))
+}
+
+func TestBatcherGroupWALReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	cfg := func() *TypedBatcherGroupCfg[int] {
+		return &TypedBatcherGroupCfg[int]{
+			BatcherNum:         1,
+			BatcherConcurrency: 1,
+			MaxBatchSize:       1,
+			WALDir:             dir,
+			WALCodec:           intCodec{},
+			WALSegmentSize:     4,
+		}
+	}
+
+	// 第一个实例处理到10时卡住, 模拟进程崩溃
+	var (
+		mu   sync.Mutex
+		done []int
+	)
+	bg := NewTypedBatcherGroup(cfg())
+	bg.Start(func(items []int) error {
+		if items[0] >= 10 {
+			select {}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, items...)
+		return nil
+	})
+	for i := 0; i < 16; i++ {
+		assert.Empty(t, bg.Put("key", i))
+	}
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stat, err := bg.CloseCtx(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, stat.Lost > 0)
+
+	mu.Lock()
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, done)
+	mu.Unlock()
+
+	// 第二个实例重新投递所有未确认的事务
+	var replayed []int
+	bg = NewTypedBatcherGroup(cfg())
+	bg.Start(func(items []int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		replayed = append(replayed, items...)
+		return nil
+	})
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	sort.Ints(replayed)
+	assert.Equal(t, []int{10, 11, 12, 13, 14, 15}, replayed)
+	assert.Empty(t, walFiles(t, dir))
+
+	assert.Nil(t, NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{WALDir: dir}))
+}
+
+func TestBatcherGroupWALFailedBatch(t *testing.T) {
+	dir := t.TempDir()
+	cfg := func(deadLetter TypedDeadLetter[int]) *TypedBatcherGroupCfg[int] {
+		return &TypedBatcherGroupCfg[int]{
+			BatcherNum:   1,
+			MaxBatchSize: 2,
+			FlushTimeMs:  10,
+			DeadLetter:   deadLetter,
+			WALDir:       dir,
+			WALCodec:     intCodec{},
+		}
+	}
+	fail := func(items []int) error {
+		return errors.New("transient failure")
+	}
+
+	// 没有死信处理, 失败的批次不确认
+	bg := NewTypedBatcherGroup(cfg(nil))
+	bg.Start(fail)
+	for i := 0; i < 6; i++ {
+		assert.Empty(t, bg.Put("key", i))
+	}
+	_, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	// 重启后重新投递, 交给死信处理的批次被确认
+	var (
+		mu           sync.Mutex
+		deadLettered []int
+	)
+	bg = NewTypedBatcherGroup(cfg(func(item *Batch[int], err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		deadLettered = append(deadLettered, item.Items[:item.NextItemIdx]...)
+	}))
+	bg.Start(fail)
+	_, err = bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	sort.Ints(deadLettered)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, deadLettered)
+	assert.Empty(t, walFiles(t, dir))
+}
+
+func TestBatcherGroupWALRejectedPut(t *testing.T) {
+	dir := t.TempDir()
+	bg := NewTypedBatcherGroup(&TypedBatcherGroupCfg[int]{
+		BatcherNum:      1,
+		SourceQueueSize: 5,
+		OverflowMode:    OverflowFailFast,
+		WALDir:          dir,
+		WALCodec:        intCodec{},
+	})
+	rejected := 0
+	for i := 0; i < 20; i++ {
+		if err := bg.TryPut("key", i); err != nil {
+			assert.Equal(t, ErrQueueFull, err)
+			rejected++
+		}
+	}
+	assert.Equal(t, 15, rejected)
+	_, err := bg.CloseCtx(context.Background())
+	assert.Empty(t, err)
+
+	// 被拒绝的事务已经确认, 只有队列中未处理的事务需要重新投递
+	_, records, err := openWAL(dir, 0, false)
+	assert.Empty(t, err)
+	var pending []int
+	for _, rec := range records {
+		job, err := intCodec{}.Unmarshal(rec.payload)
+		assert.Empty(t, err)
+		pending = append(pending, job)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, pending)
+}
+
+func TestWALAckPersistence(t *testing.T) {
+	for _, sync := range []bool{false, true} {
+		dir := t.TempDir()
+		w, _, err := openWAL(dir, 4, sync)
+		assert.Empty(t, err)
+		for i := 0; i < 3; i++ {
+			_, err := w.append("key", []byte{byte(i)})
+			assert.Empty(t, err)
+		}
+		w.ack([]uint64{0})
+
+		// 不开启sync时确认只保存在内存中, 关闭时才写入位图
+		_, err = os.Stat(w.ackFile(0))
+		assert.Equal(t, sync, err == nil)
+		assert.Empty(t, w.close())
+		_, err = os.Stat(w.ackFile(0))
+		assert.Empty(t, err)
+
+		_, records, err := openWAL(dir, 4, sync)
+		assert.Empty(t, err)
+		var seqs []uint64
+		for _, rec := range records {
+			seqs = append(seqs, rec.seq)
+		}
+		assert.Equal(t, []uint64{1, 2}, seqs)
+	}
+}
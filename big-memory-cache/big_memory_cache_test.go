@@ -0,0 +1,65 @@
+package bigmemcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type removal struct {
+	uuid   string
+	reason RemoveReason
+}
+
+func TestBigMemCacheTTL(t *testing.T) {
+	var mu sync.Mutex
+	var removals []removal
+	bmc, err := NewBigMemCache(&BigMemCacheCfg{
+		MaxNumOfCacheItem:  1000,
+		MaxSizeOfCacheItem: 1024,
+		DefaultTTL:         50 * time.Millisecond,
+		CleanWindow:        10 * time.Millisecond,
+		OnRemove: func(uuid string, fe *Feature, reason RemoveReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, uuid, fe.UUID)
+			removals = append(removals, removal{uuid, reason})
+		},
+	})
+	assert.Empty(t, err)
+	defer bmc.Close()
+
+	assert.Empty(t, bmc.Add(&Feature{UUID: "default", Blob: []byte("blob")}))
+	assert.Empty(t, bmc.AddWithTTL(&Feature{UUID: "forever", Blob: []byte("blob")}, 0))
+	assert.Empty(t, bmc.AddWithTTL(&Feature{UUID: "deleted", Blob: []byte("blob")}, time.Hour))
+	assert.Empty(t, bmc.Del("deleted"))
+
+	fe := bmc.Get("default")
+	assert.NotNil(t, fe)
+	assert.Equal(t, []byte("blob"), fe.Blob)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Nil(t, bmc.Get("default"))
+	assert.NotNil(t, bmc.Get("forever"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []removal{{"deleted", RemoveDeleted}, {"default", RemoveExpired}}, removals)
+}
+
+func TestBigMemCacheLazyExpiration(t *testing.T) {
+	bmc, err := NewBigMemCache(&BigMemCacheCfg{
+		MaxNumOfCacheItem:  1000,
+		MaxSizeOfCacheItem: 1024,
+	})
+	assert.Empty(t, err)
+	defer bmc.Close()
+
+	assert.Empty(t, bmc.AddWithTTL(&Feature{UUID: "uuid"}, time.Millisecond))
+	assert.Equal(t, 1, bmc.Size())
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, bmc.Get("uuid"))
+	assert.Equal(t, 0, bmc.Size())
+}
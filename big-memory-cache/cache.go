@@ -0,0 +1,338 @@
+package bigmemcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/allegro/bigcache"
+)
+
+var (
+	ErrNotFound         = errors.New("not found in cache")
+	errCorruptedEntry   = errors.New("corrupted cache entry")
+	errLoaderNotReturns = errors.New("loader does not return")
+)
+
+// DecodeError 缓存中的对象解码失败.
+type DecodeError struct {
+	Key string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s: %v", e.Key, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// RemoveReason 对象被移出缓存的原因, 取值与bigcache.RemoveReason一致.
+type RemoveReason uint32
+
+const (
+	// RemoveExpired 对象已过期, 过期对象被Del删除或因空间不足被淘汰时也归为此类.
+	RemoveExpired RemoveReason = iota
+	// RemoveNoSpace 缓存空间不足, 淘汰了最早写入的对象.
+	RemoveNoSpace
+	// RemoveDeleted 对象被Del删除.
+	RemoveDeleted
+)
+
+func (r RemoveReason) String() string {
+	switch r {
+	case RemoveExpired:
+		return "expired"
+	case RemoveNoSpace:
+		return "no_space"
+	case RemoveDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// Codec 缓存对象的编解码器.
+type Codec[V any] interface {
+	Encode(v V) ([]byte, error)
+	Decode(raw []byte) (V, error)
+}
+
+// CacheCfg Cache配置
+type CacheCfg[V any] struct {
+	MaxNumOfCacheItem  uint64        // 最多可缓存的对象数量
+	MaxSizeOfCacheItem uint64        // 对象大小, unit is byte
+	DefaultTTL         time.Duration // Add写入对象的存活时间, 0表示永不过期
+	CleanWindow        time.Duration // 后台清理过期对象的时间间隔, 0表示不清理, 过期对象仅在Get时被删除
+	// OnRemove 对象被移出缓存时的回调, 解码失败时v为零值.
+	// 回调在bigcache的分片锁内执行, 不能再调用缓存的方法.
+	// 注意: 被覆盖或删除的旧版本对象在被淘汰时也会触发回调.
+	OnRemove func(key string, v V, reason RemoveReason)
+}
+
+func (cfg *CacheCfg[V]) defaultBigCacheCfg() bigcache.Config {
+	bcCfg := bigcache.DefaultConfig(__DefaultEvictionTime)
+	bcCfg.Verbose = false
+
+	shardsUpLimit := uint(cfg.MaxNumOfCacheItem/__DefaultShardsFactor) + 1
+	bcCfg.Shards = int(findNearestPowerOf2Num(shardsUpLimit))
+	if bcCfg.Shards > __DefaultMaxShards {
+		bcCfg.Shards = __DefaultMaxShards
+	}
+
+	// init 10 entries for each shard.
+	bcCfg.MaxEntriesInWindow = 10 * bcCfg.Shards
+	bcCfg.MaxEntrySize = int(cfg.MaxSizeOfCacheItem)
+
+	bcCfg.HardMaxCacheSize = int((cfg.MaxNumOfCacheItem*cfg.MaxSizeOfCacheItem)/__OneMB) + 1
+	return bcCfg
+}
+
+// Cache stores items of type V serialized by codec in memory.
+// Item is serialized as []byte to avoid excessive GC stress and extra memory footprint.
+// Every item is prefixed with its expiration time in unix nanoseconds (0 means never expire) and its key, see encodeEntry.
+type Cache[V any] struct {
+	cache *bigcache.BigCache
+	cfg   CacheCfg[V]
+	codec Codec[V]
+
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	// loads 正在加载的对象, 同一个key的并发加载只调用一次loader
+	mu    sync.Mutex
+	loads map[string]*load[V]
+}
+
+type load[V any] struct {
+	v     V
+	err   error
+	ready chan struct{}
+}
+
+// NewCache 返回以codec编解码对象的Cache实例.
+func NewCache[V any](cfg *CacheCfg[V], codec Codec[V]) (*Cache[V], error) {
+	c := &Cache[V]{
+		cfg:   *cfg,
+		codec: codec,
+		quit:  make(chan struct{}),
+		loads: make(map[string]*load[V]),
+	}
+
+	bcCfg := cfg.defaultBigCacheCfg()
+	if cfg.OnRemove != nil {
+		bcCfg.OnRemoveWithReason = c.onRemove
+	}
+	cache, err := bigcache.NewBigCache(bcCfg)
+	if err != nil {
+		return nil, err
+	}
+	c.cache = cache
+
+	if cfg.CleanWindow > 0 {
+		go c.cleaner()
+	}
+	return c, nil
+}
+
+// Add 将对象添加进缓存, 存活时间为DefaultTTL.
+func (c *Cache[V]) Add(key string, v V) error {
+	return c.AddWithTTL(key, v, c.cfg.DefaultTTL)
+}
+
+// AddWithTTL 将对象添加进缓存, 存活时间为ttl, ttl <= 0表示永不过期.
+func (c *Cache[V]) AddWithTTL(key string, v V, ttl time.Duration) error {
+	encoded, err := c.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl).UnixNano()
+	}
+	return c.cache.Set(key, encodeEntry(key, expireAt, encoded))
+}
+
+// Del 将对象从缓存删除.
+func (c *Cache[V]) Del(key string) error {
+	// mark-deletion in bigcache
+	return c.cache.Delete(key)
+}
+
+// Get 从缓存中获取对象, 未命中或解码失败时返回false, 过期对象会被删除.
+func (c *Cache[V]) Get(key string) (V, bool) {
+	v, err := c.Lookup(key)
+	return v, err == nil
+}
+
+// Lookup 从缓存中获取对象, 未命中时返回ErrNotFound, 解码失败时返回*DecodeError, 过期对象会被删除.
+func (c *Cache[V]) Lookup(key string) (V, error) {
+	var zero V
+	v, err := c.cache.Get(key)
+	if err != nil {
+		return zero, ErrNotFound
+	}
+	_, encoded, ok := decodeEntry(v)
+	if !ok {
+		return zero, &DecodeError{Key: key, Err: errCorruptedEntry}
+	}
+	if expired(v, time.Now().UnixNano()) {
+		// 与Get之间写入的新对象可能一并被删除, 对缓存而言只是多一次未命中
+		c.cache.Delete(key) // nolint
+		return zero, ErrNotFound
+	}
+	decoded, err := c.codec.Decode(encoded)
+	if err != nil {
+		return zero, &DecodeError{Key: key, Err: err}
+	}
+	return decoded, nil
+}
+
+// GetOrLoad 从缓存中获取对象, 未命中或解码失败时调用loader加载对象并以DefaultTTL写入缓存.
+// 同一个key的并发加载只调用一次loader, 其他调用者等待并共享其结果.
+// loader返回的错误原样返回且不会被缓存, 写入缓存失败时仍返回加载的对象.
+func (c *Cache[V]) GetOrLoad(key string, loader func(key string) (V, error)) (V, error) {
+	if v, err := c.Lookup(key); err == nil {
+		return v, nil
+	}
+
+	c.mu.Lock()
+	l := c.loads[key]
+	if l != nil {
+		c.mu.Unlock()
+		<-l.ready // wait until the loader returns
+		return l.v, l.err
+	}
+	/*
+		if it's the first time to load the key, current goroutine will be in charge
+		of calling the loader and broadcast the done signal.
+	*/
+	l = &load[V]{
+		err:   errLoaderNotReturns, // loader panics
+		ready: make(chan struct{}),
+	}
+	c.loads[key] = l
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.loads, key)
+		c.mu.Unlock()
+		close(l.ready) // broadcast the done signal
+	}()
+
+	l.v, l.err = loader(key)
+	if l.err == nil {
+		c.Add(key, l.v) // nolint
+	}
+	return l.v, l.err
+}
+
+// Size 返回当前缓存的对象数量.
+func (c *Cache[V]) Size() int {
+	return c.cache.Len()
+}
+
+// Reset 真正意义上去清理缓存.
+func (c *Cache[V]) Reset() error {
+	return c.cache.Reset()
+}
+
+// Close 停止后台清理并关闭缓存.
+func (c *Cache[V]) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.quit)
+		err = c.cache.Close()
+	})
+	return err
+}
+
+// cleaner 每隔CleanWindow删除一次过期对象.
+func (c *Cache[V]) cleaner() {
+	ticker := time.NewTicker(c.cfg.CleanWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.cleanUp()
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+func (c *Cache[V]) cleanUp() {
+	now := time.Now().UnixNano()
+	it := c.cache.Iterator()
+	for it.SetNext() {
+		entry, err := it.Value()
+		if err != nil {
+			continue
+		}
+		key, _, ok := decodeEntry(entry.Value())
+		if ok && expired(entry.Value(), now) {
+			c.cache.Delete(key) // nolint
+		}
+	}
+}
+
+func (c *Cache[V]) onRemove(key string, v []byte, reason bigcache.RemoveReason) {
+	var zero V
+	entryKey, encoded, ok := decodeEntry(v)
+	if !ok {
+		c.cfg.OnRemove(string(append([]byte(nil), key...)), zero, RemoveReason(reason))
+		return
+	}
+	key = entryKey
+
+	r := RemoveReason(reason)
+	if expired(v, time.Now().UnixNano()) {
+		r = RemoveExpired
+	}
+	decoded, err := c.codec.Decode(encoded)
+	if err != nil {
+		decoded = zero
+	}
+	c.cfg.OnRemove(key, decoded, r)
+}
+
+/*
+	bigcache v1.2.1的readKeyFromEntry将新分配的缓冲区经unsafe转换为string, 编译器看不到string对缓冲区的引用,
+	较新的Go编译器(1.25+)会把该缓冲区分配在栈上, 迭代器与回调返回的key在函数返回后可能已被覆盖.
+	因此对象中保存一份key, cleanUp与onRemove使用保存的key.
+*/
+
+// encodeEntry 返回写入bigcache的对象: 过期时间 u64 | uvarint(len(key)) | key | 编码后的对象.
+func encodeEntry(key string, expireAt int64, encoded []byte) []byte {
+	entry := make([]byte, __ExpireAtSize+binary.MaxVarintLen64+len(key)+len(encoded))
+	binary.LittleEndian.PutUint64(entry, uint64(expireAt))
+	n := __ExpireAtSize + binary.PutUvarint(entry[__ExpireAtSize:], uint64(len(key)))
+	n += copy(entry[n:], key)
+	n += copy(entry[n:], encoded)
+	return entry[:n]
+}
+
+// decodeEntry 解析encodeEntry写入的对象, 返回其key与编码后的对象.
+func decodeEntry(entry []byte) (string, []byte, bool) {
+	if len(entry) < __ExpireAtSize {
+		return "", nil, false
+	}
+	keyLen, n := binary.Uvarint(entry[__ExpireAtSize:])
+	if n <= 0 || keyLen > uint64(len(entry)-__ExpireAtSize-n) {
+		return "", nil, false
+	}
+	start := __ExpireAtSize + n
+	end := start + int(keyLen)
+	return string(entry[start:end]), entry[end:], true
+}
+
+// expired 判断对象在now时刻是否已过期.
+func expired(v []byte, now int64) bool {
+	expireAt := int64(binary.LittleEndian.Uint64(v))
+	return expireAt > 0 && expireAt <= now
+}
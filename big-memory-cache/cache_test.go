@@ -0,0 +1,134 @@
+package bigmemcache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	Name  string
+	Score float64
+	Tags  []string
+}
+
+func testCodec[V any](t *testing.T, codec Codec[V], v V) {
+	c, err := NewCache[V](&CacheCfg[V]{
+		MaxNumOfCacheItem:  1000,
+		MaxSizeOfCacheItem: 1024,
+	}, codec)
+	assert.Empty(t, err)
+	defer c.Close()
+
+	_, ok := c.Get("key")
+	assert.False(t, ok)
+	assert.Empty(t, c.Add("key", v))
+	got, ok := c.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, v, got)
+	assert.Empty(t, c.Del("key"))
+	_, ok = c.Get("key")
+	assert.False(t, ok)
+}
+
+func TestCacheCodecs(t *testing.T) {
+	v := item{Name: "name", Score: 0.5, Tags: []string{"a", "b"}}
+	testCodec[item](t, GobCodec[item]{}, v)
+	testCodec[*item](t, JSONCodec[*item]{}, &v)
+	testCodec[[]byte](t, RawCodec{}, []byte("raw"))
+	testCodec[*Feature](t, FeatureCodec{}, &Feature{UUID: "uuid", Meta: []byte{}, Blob: []byte("blob")})
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	c, err := NewCache[[]byte](&CacheCfg[[]byte]{
+		MaxNumOfCacheItem:  1000,
+		MaxSizeOfCacheItem: 1024,
+	}, RawCodec{})
+	assert.Empty(t, err)
+	defer c.Close()
+
+	_, err = c.Lookup("key")
+	assert.Equal(t, ErrNotFound, err)
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(key string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("value of " + key), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad("key", loader)
+			assert.Empty(t, err)
+			assert.Equal(t, []byte("value of key"), v)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// 已写入缓存, 不再调用loader
+	v, err := c.GetOrLoad("key", loader)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("value of key"), v)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// loader的错误不会被缓存
+	errLoad := errors.New("load failed")
+	_, err = c.GetOrLoad("other", func(string) ([]byte, error) { return nil, errLoad })
+	assert.Equal(t, errLoad, err)
+	_, err = c.Lookup("other")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestCacheLookupDecodeError(t *testing.T) {
+	c, err := NewCache[*Feature](&CacheCfg[*Feature]{
+		MaxNumOfCacheItem:  1000,
+		MaxSizeOfCacheItem: 1024,
+	}, FeatureCodec{})
+	assert.Empty(t, err)
+	defer c.Close()
+
+	// 写入无法解码的对象
+	assert.Empty(t, c.cache.Set("uuid", encodeEntry("uuid", 0, []byte{0, 0, 0})))
+
+	_, err = c.Lookup("uuid")
+	var de *DecodeError
+	assert.True(t, errors.As(err, &de))
+	assert.Equal(t, "uuid", de.Key)
+	assert.NotEqual(t, ErrNotFound, err)
+
+	fe, err := c.GetOrLoad("uuid", func(uuid string) (*Feature, error) {
+		return &Feature{UUID: uuid, Meta: []byte{}, Blob: []byte("blob")}, nil
+	})
+	assert.Empty(t, err)
+	assert.Equal(t, "uuid", fe.UUID)
+	got, err := c.Lookup("uuid")
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("blob"), got.Blob)
+}
+
+func TestCacheEntry(t *testing.T) {
+	entry := encodeEntry("hello-key", 42, []byte("encoded"))
+	assert.False(t, expired(entry, 41))
+	assert.True(t, expired(entry, 42))
+	key, encoded, ok := decodeEntry(entry)
+	assert.True(t, ok)
+	assert.Equal(t, "hello-key", key)
+	assert.Equal(t, []byte("encoded"), encoded)
+
+	_, _, ok = decodeEntry(entry[:__ExpireAtSize+5])
+	assert.False(t, ok)
+	_, _, ok = decodeEntry(entry[:__ExpireAtSize-1])
+	assert.False(t, ok)
+}
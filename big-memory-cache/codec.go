@@ -0,0 +1,67 @@
+package bigmemcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// FeatureCodec 以BigMemCache的二进制格式编解码特征对象.
+type FeatureCodec struct{}
+
+// Encode 编码特征对象.
+func (FeatureCodec) Encode(fe *Feature) ([]byte, error) {
+	return encodeFeature(fe)
+}
+
+// Decode 解码特征对象.
+func (FeatureCodec) Decode(raw []byte) (*Feature, error) {
+	return decodeFeature(raw)
+}
+
+// GobCodec 以encoding/gob编解码对象, 每个对象都携带完整的类型信息.
+type GobCodec[V any] struct{}
+
+// Encode 编码对象.
+func (GobCodec[V]) Encode(v V) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode 解码对象.
+func (GobCodec[V]) Decode(raw []byte) (V, error) {
+	var v V
+	err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&v)
+	return v, err
+}
+
+// JSONCodec 以encoding/json编解码对象.
+type JSONCodec[V any] struct{}
+
+// Encode 编码对象.
+func (JSONCodec[V]) Encode(v V) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode 解码对象.
+func (JSONCodec[V]) Decode(raw []byte) (V, error) {
+	var v V
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// RawCodec 直接缓存字节数组, 不做任何编解码.
+type RawCodec struct{}
+
+// Encode 返回b本身.
+func (RawCodec) Encode(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+// Decode 返回raw本身, raw已经是缓存中数据的拷贝.
+func (RawCodec) Decode(raw []byte) ([]byte, error) {
+	return raw, nil
+}
@@ -0,0 +1,243 @@
+package bigmemcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/usherasnick/Useful-Go-Gadgets/fwriter"
+)
+
+/*
+	dump的格式如下, 整数均为小端序:
+
+	magic "BMCD" | version u32
+	record: 1 | uvarint(len(entry)) | entry | crc32(entry) u32
+	...
+	trailer: 0 | count u64 | crc32(之前的全部字节) u32
+
+	entry为缓存中的原始字节(过期时间+key+编码后的对象), 因此dump与codec无关.
+*/
+
+const (
+	__DumpMagic   = "BMCD"
+	__DumpVersion = 1
+
+	__DumpRecord  = 1
+	__DumpTrailer = 0
+)
+
+var (
+	ErrCorruptedDump   = errors.New("corrupted cache dump")
+	ErrUnsupportedDump = errors.New("unsupported cache dump version")
+)
+
+// Dump 将缓存中所有未过期的对象写入w, 返回写入的对象数量.
+// Dump期间写入的对象可能不会被包含在内.
+func (c *Cache[V]) Dump(w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	sum := crc32.NewIEEE()
+	cw := io.MultiWriter(bw, sum)
+
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(x uint64) error {
+		_, err := cw.Write(buf[:binary.PutUvarint(buf[:], x)])
+		return err
+	}
+	putUint32 := func(x uint32) error {
+		binary.LittleEndian.PutUint32(buf[:4], x)
+		_, err := cw.Write(buf[:4])
+		return err
+	}
+
+	if _, err := io.WriteString(cw, __DumpMagic); err != nil {
+		return 0, err
+	}
+	if err := putUint32(__DumpVersion); err != nil {
+		return 0, err
+	}
+
+	var count int
+	now := time.Now().UnixNano()
+	it := c.cache.Iterator()
+	for it.SetNext() {
+		entry, err := it.Value()
+		if err != nil {
+			continue
+		}
+		v := entry.Value()
+		if _, _, ok := decodeEntry(v); !ok || expired(v, now) {
+			continue
+		}
+
+		if _, err := cw.Write([]byte{__DumpRecord}); err != nil {
+			return count, err
+		}
+		if err := putUvarint(uint64(len(v))); err != nil {
+			return count, err
+		}
+		if _, err := cw.Write(v); err != nil {
+			return count, err
+		}
+		if err := putUint32(crc32.ChecksumIEEE(v)); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if _, err := cw.Write([]byte{__DumpTrailer}); err != nil {
+		return count, err
+	}
+	binary.LittleEndian.PutUint64(buf[:8], uint64(count))
+	if _, err := cw.Write(buf[:8]); err != nil {
+		return count, err
+	}
+	binary.LittleEndian.PutUint32(buf[:4], sum.Sum32())
+	if _, err := bw.Write(buf[:4]); err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// Load 从r读取Dump写入的对象并添加进缓存, 已过期的对象会被跳过, 返回添加的对象数量.
+// 每个对象都单独校验, 出错时已添加的对象保留在缓存中.
+// Load可能从r中多读取dump之后的字节.
+func (c *Cache[V]) Load(r io.Reader) (int, error) {
+	sum := crc32.NewIEEE()
+	dr := &dumpReader{r: bufio.NewReader(r), sum: sum}
+
+	var header [8]byte
+	if err := dr.readFull(header[:]); err != nil {
+		return 0, err
+	}
+	if string(header[:4]) != __DumpMagic {
+		return 0, ErrCorruptedDump
+	}
+	if binary.LittleEndian.Uint32(header[4:]) != __DumpVersion {
+		return 0, ErrUnsupportedDump
+	}
+
+	// 对象不会超过缓存的容量, 防止损坏的长度导致分配过多内存
+	maxEntrySize := uint64(c.cfg.defaultBigCacheCfg().HardMaxCacheSize) * __OneMB
+
+	var loaded, count int
+	now := time.Now().UnixNano()
+	for {
+		flag, err := dr.ReadByte()
+		if err != nil {
+			return loaded, err
+		}
+		if flag == __DumpTrailer {
+			break
+		}
+		if flag != __DumpRecord {
+			return loaded, ErrCorruptedDump
+		}
+
+		v, err := dr.readBytes(maxEntrySize)
+		if err != nil {
+			return loaded, err
+		}
+		var crc [4]byte
+		if err := dr.readFull(crc[:]); err != nil {
+			return loaded, err
+		}
+		key, _, ok := decodeEntry(v)
+		if !ok || crc32.ChecksumIEEE(v) != binary.LittleEndian.Uint32(crc[:]) {
+			return loaded, ErrCorruptedDump
+		}
+		count++
+
+		if expired(v, now) {
+			continue
+		}
+		if err := c.cache.Set(key, v); err != nil {
+			return loaded, err
+		}
+		loaded++
+	}
+
+	var trailer [8]byte
+	if err := dr.readFull(trailer[:]); err != nil {
+		return loaded, err
+	}
+	expected := sum.Sum32()
+	var crc [4]byte
+	if err := dr.readFull(crc[:]); err != nil {
+		return loaded, err
+	}
+	if binary.LittleEndian.Uint64(trailer[:]) != uint64(count) ||
+		binary.LittleEndian.Uint32(crc[:]) != expected {
+		return loaded, ErrCorruptedDump
+	}
+	return loaded, nil
+}
+
+// DumpFile 将缓存Dump到文件fn, 文件通过fwriter.SafeWriter原子写入.
+func (c *Cache[V]) DumpFile(fn string) (int, error) {
+	w, err := fwriter.NewSafeWriter(fn)
+	if err != nil {
+		return 0, err
+	}
+	count, err := c.Dump(w)
+	if err != nil {
+		w.Abort()
+		return count, err
+	}
+	return count, w.Commit()
+}
+
+// LoadFile 从DumpFile写入的文件fn恢复缓存.
+func (c *Cache[V]) LoadFile(fn string) (int, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() // nolint
+	return c.Load(f)
+}
+
+// dumpReader 读取dump并计算已读字节的校验和, 提前结束的dump视为损坏.
+type dumpReader struct {
+	r   *bufio.Reader
+	sum hash.Hash32
+}
+
+func (dr *dumpReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(dr.r, p); err != nil {
+		return unexpectedEOF(err)
+	}
+	dr.sum.Write(p) // nolint
+	return nil
+}
+
+// ReadByte 实现io.ByteReader, 供binary.ReadUvarint使用.
+func (dr *dumpReader) ReadByte() (byte, error) {
+	var b [1]byte
+	err := dr.readFull(b[:])
+	return b[0], err
+}
+
+func (dr *dumpReader) readBytes(max uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(dr)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n > max {
+		return nil, ErrCorruptedDump
+	}
+	p := make([]byte, n)
+	return p, dr.readFull(p)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorruptedDump
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package bigmemcache
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBigMemCache(t *testing.T) *BigMemCache {
+	bmc, err := NewBigMemCache(&BigMemCacheCfg{
+		MaxNumOfCacheItem:  1000,
+		MaxSizeOfCacheItem: 1024,
+	})
+	assert.Empty(t, err)
+	return bmc
+}
+
+func TestBigMemCacheDumpLoad(t *testing.T) {
+	bmc := newTestBigMemCache(t)
+	defer bmc.Close()
+
+	for i := 0; i < 100; i++ {
+		uuid := fmt.Sprintf("uuid-%d", i)
+		assert.Empty(t, bmc.AddWithTTL(&Feature{Version: 1, UUID: uuid, Meta: []byte{}, Blob: []byte(uuid)}, time.Hour))
+	}
+	assert.Empty(t, bmc.AddWithTTL(&Feature{UUID: "expiring", Blob: []byte("blob")}, 50*time.Millisecond))
+
+	fn := filepath.Join(t.TempDir(), "cache.dump")
+	n, err := bmc.DumpFile(fn)
+	assert.Empty(t, err)
+	assert.Equal(t, 101, n)
+
+	time.Sleep(100 * time.Millisecond)
+
+	restored := newTestBigMemCache(t)
+	defer restored.Close()
+	n, err = restored.LoadFile(fn)
+	assert.Empty(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, 100, restored.Size())
+	assert.Nil(t, restored.Get("expiring"))
+	for i := 0; i < 100; i++ {
+		uuid := fmt.Sprintf("uuid-%d", i)
+		fe := restored.Get(uuid)
+		assert.NotNil(t, fe)
+		assert.Equal(t, []byte(uuid), fe.Blob)
+		assert.Equal(t, int32(1), fe.Version)
+	}
+}
+
+func TestBigMemCacheLoadCorrupted(t *testing.T) {
+	bmc := newTestBigMemCache(t)
+	defer bmc.Close()
+
+	assert.Empty(t, bmc.Add(&Feature{UUID: "a", Blob: []byte("blob")}))
+	assert.Empty(t, bmc.Add(&Feature{UUID: "b", Blob: []byte("blob")}))
+
+	var buf bytes.Buffer
+	n, err := bmc.Dump(&buf)
+	assert.Empty(t, err)
+	assert.Equal(t, 2, n)
+	dump := buf.Bytes()
+
+	restored := newTestBigMemCache(t)
+	defer restored.Close()
+
+	// 截断
+	for _, size := range []int{0, 6, len(dump) / 2, len(dump) - 1} {
+		_, err = restored.Load(bytes.NewReader(dump[:size]))
+		assert.Equal(t, ErrCorruptedDump, err, size)
+	}
+
+	// 损坏的对象
+	corrupted := append([]byte(nil), dump...)
+	corrupted[len(corrupted)-20] ^= 0xff
+	_, err = restored.Load(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrCorruptedDump, err)
+
+	// 损坏的trailer
+	corrupted = append([]byte(nil), dump...)
+	corrupted[len(corrupted)-5] ^= 0xff
+	_, err = restored.Load(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrCorruptedDump, err)
+
+	corrupted = append([]byte(nil), dump...)
+	corrupted[4] = 2
+	_, err = restored.Load(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrUnsupportedDump, err)
+
+	assert.Empty(t, restored.Reset())
+	n, err = restored.Load(bytes.NewReader(dump))
+	assert.Empty(t, err)
+	assert.Equal(t, 2, n)
+	assert.NotNil(t, restored.Get("a"))
+}
@@ -2,7 +2,23 @@ package bigmemcache
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+)
+
+const (
+	// __FeatureLayoutV1 旧格式, 以uint16存储UUID, Meta和Blob的长度, 首4字节为总长度.
+	__FeatureLayoutV1 = 1
+	// __FeatureLayoutV2 新格式, 以uvarint存储UUID, Meta和Blob的长度, 首4字节为0以区别于旧格式.
+	__FeatureLayoutV2 = 2
+	// __MaxFieldSize UUID, Meta和Blob的最大长度
+	__MaxFieldSize = math.MaxUint32
+)
+
+var (
+	ErrFieldTooLarge    = errors.New("field of feature is too large")
+	ErrCorruptedFeature = errors.New("corrupted feature")
 )
 
 func findNearestPowerOf2Num(n uint) uint {
@@ -16,7 +32,7 @@ func findNearestPowerOf2Num(n uint) uint {
 	return k
 }
 
-func (c *BigMemCache) encode(fe *Feature) ([]byte, error) {
+func encodeFeature(fe *Feature) ([]byte, error) {
 	/*
 		type Feature struct {
 			Version     int32
@@ -26,36 +42,37 @@ func (c *BigMemCache) encode(fe *Feature) ([]byte, error) {
 			CreatedTime int64
 		}
 	*/
-	totalLen := 4 + // totalLen
+	for _, n := range []int{len(fe.UUID), len(fe.Meta), len(fe.Blob)} {
+		if uint64(n) > __MaxFieldSize {
+			return nil, ErrFieldTooLarge
+		}
+	}
+
+	totalLen := 4 + // zero, V1中为totalLen
+		1 + // layout version
 		4 + // Version
-		2 + len(fe.UUID) +
-		2 + len(fe.Meta) +
-		2 + len(fe.Blob) +
+		uvarintSize(len(fe.UUID)) + len(fe.UUID) +
+		uvarintSize(len(fe.Meta)) + len(fe.Meta) +
+		uvarintSize(len(fe.Blob)) + len(fe.Blob) +
 		8 // CreatedTime
 	raw := make([]byte, totalLen)
 
-	pos := 0
-	binary.LittleEndian.PutUint32(raw[pos:], uint32(totalLen))
-	pos += 4
+	pos := 4
+	raw[pos] = __FeatureLayoutV2
+	pos++
 
 	binary.LittleEndian.PutUint32(raw[pos:], uint32(fe.Version))
 	pos += 4
 
 	// 对于[]byte或string类型来说, 先存大小, 再存实际的字节
-	binary.LittleEndian.PutUint16(raw[pos:], uint16(len(fe.UUID)))
-	pos += 2
-	copy(raw[pos:], []byte(fe.UUID))
-	pos += len(fe.UUID)
+	pos += binary.PutUvarint(raw[pos:], uint64(len(fe.UUID)))
+	pos += copy(raw[pos:], fe.UUID)
 
-	binary.LittleEndian.PutUint16(raw[pos:], uint16(len(fe.Meta)))
-	pos += 2
-	copy(raw[pos:], fe.Meta)
-	pos += len(fe.Meta)
+	pos += binary.PutUvarint(raw[pos:], uint64(len(fe.Meta)))
+	pos += copy(raw[pos:], fe.Meta)
 
-	binary.LittleEndian.PutUint16(raw[pos:], uint16(len(fe.Blob)))
-	pos += 2
-	copy(raw[pos:], fe.Blob)
-	pos += len(fe.Blob)
+	pos += binary.PutUvarint(raw[pos:], uint64(len(fe.Blob)))
+	pos += copy(raw[pos:], fe.Blob)
 
 	binary.LittleEndian.PutUint64(raw[pos:], uint64(fe.CreatedTime))
 	pos += 8
@@ -67,51 +84,122 @@ func (c *BigMemCache) encode(fe *Feature) ([]byte, error) {
 	return raw, nil
 }
 
-func (c *BigMemCache) decode(raw []byte) (*Feature, error) {
-	/*
-		type Feature struct {
-			Version     int32
-			UUID        string
-			Meta        []byte
-			Blob        []byte
-			CreatedTime int64
-		}
-	*/
+func decodeFeature(raw []byte) (*Feature, error) {
+	if len(raw) < 5 {
+		return nil, ErrCorruptedFeature
+	}
+	if binary.LittleEndian.Uint32(raw) != 0 {
+		return decodeV1(raw)
+	}
+	if raw[4] != __FeatureLayoutV2 {
+		return nil, fmt.Errorf("unsupported feature layout %v", raw[4])
+	}
+	return decodeV2(raw)
+}
+
+// decodeV1 解码旧格式.
+func decodeV1(raw []byte) (*Feature, error) {
 	totalLen := len(raw)
+	r := featureReader{raw: raw}
 
-	pos := 0
-	storedTotalLen := binary.LittleEndian.Uint32(raw[pos:])
+	storedTotalLen := r.uint32()
 	if storedTotalLen != uint32(totalLen) {
 		return nil, fmt.Errorf("StoredTotalLen(%v) != TotalLen(%v)", storedTotalLen, totalLen)
 	}
-	pos += 4
 
 	var fe Feature
+	fe.Version = int32(r.uint32())
+	fe.UUID = string(r.bytes(uint64(r.uint16())))
+	fe.Meta = r.bytes(uint64(r.uint16()))
+	fe.Blob = r.bytes(uint64(r.uint16()))
+	fe.CreatedTime = int64(r.uint64())
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != totalLen {
+		return nil, fmt.Errorf("failed to decode feature, Pos(%v) != StoredTotalLen(%v)", r.pos, totalLen)
+	}
+	return &fe, nil
+}
 
-	fe.Version = int32(binary.LittleEndian.Uint32(raw[pos:]))
-	pos += 4
+// decodeV2 解码新格式.
+func decodeV2(raw []byte) (*Feature, error) {
+	totalLen := len(raw)
+	r := featureReader{raw: raw, pos: 5}
 
-	uuidLen := binary.LittleEndian.Uint16(raw[pos:])
-	pos += 2
-	fe.UUID = string(raw[pos : pos+int(uuidLen)])
-	pos += int(uuidLen)
+	var fe Feature
+	fe.Version = int32(r.uint32())
+	fe.UUID = string(r.bytes(r.uvarint()))
+	fe.Meta = r.bytes(r.uvarint())
+	fe.Blob = r.bytes(r.uvarint())
+	fe.CreatedTime = int64(r.uint64())
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != totalLen {
+		return nil, fmt.Errorf("failed to decode feature, Pos(%v) != TotalLen(%v)", r.pos, totalLen)
+	}
+	return &fe, nil
+}
 
-	metaLen := binary.LittleEndian.Uint16(raw[pos:])
-	pos += 2
-	fe.Meta = raw[pos : pos+int(metaLen)]
-	pos += int(metaLen)
+// featureReader 按顺序读取编码后的特征对象, 越界时记录ErrCorruptedFeature.
+type featureReader struct {
+	raw []byte
+	pos int
+	err error
+}
 
-	blobLen := binary.LittleEndian.Uint16(raw[pos:])
-	pos += 2
-	fe.Blob = raw[pos : pos+int(blobLen)]
-	pos += int(blobLen)
+func (r *featureReader) bytes(n uint64) []byte {
+	if r.err != nil || n > uint64(len(r.raw)-r.pos) {
+		r.err = ErrCorruptedFeature
+		return nil
+	}
+	b := r.raw[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b
+}
 
-	fe.CreatedTime = int64(binary.LittleEndian.Uint64(raw[pos:]))
-	pos += 8
+func (r *featureReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
 
-	if pos != totalLen {
-		return nil, fmt.Errorf("failed to decode feature, Pos(%v) != StoredTotalLen(%v)", pos, totalLen)
+func (r *featureReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
 	}
+	return 0
+}
 
-	return &fe, nil
+func (r *featureReader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *featureReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.raw[r.pos:])
+	if n <= 0 {
+		r.err = ErrCorruptedFeature
+		return 0
+	}
+	r.pos += n
+	return x
+}
+
+// uvarintSize 返回以uvarint格式编码x所需的字节数.
+func uvarintSize(x int) int {
+	size := 1
+	for ; x >= 0x80; x >>= 7 {
+		size++
+	}
+	return size
 }
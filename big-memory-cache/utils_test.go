@@ -0,0 +1,59 @@
+package bigmemcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// encodeV1 按旧格式编码特征对象.
+func encodeV1(fe *Feature) []byte {
+	totalLen := 4 + 4 + 2 + len(fe.UUID) + 2 + len(fe.Meta) + 2 + len(fe.Blob) + 8
+	raw := make([]byte, totalLen)
+	pos := 0
+	binary.LittleEndian.PutUint32(raw[pos:], uint32(totalLen))
+	pos += 4
+	binary.LittleEndian.PutUint32(raw[pos:], uint32(fe.Version))
+	pos += 4
+	for _, field := range [][]byte{[]byte(fe.UUID), fe.Meta, fe.Blob} {
+		binary.LittleEndian.PutUint16(raw[pos:], uint16(len(field)))
+		pos += 2
+		pos += copy(raw[pos:], field)
+	}
+	binary.LittleEndian.PutUint64(raw[pos:], uint64(fe.CreatedTime))
+	return raw
+}
+
+func TestFeatureCodec(t *testing.T) {
+	fe := &Feature{
+		Version:     -1,
+		UUID:        "uuid",
+		Meta:        []byte("meta"),
+		Blob:        bytes.Repeat([]byte("blob"), 100000),
+		CreatedTime: 1234567890,
+	}
+
+	raw, err := encodeFeature(fe)
+	assert.Empty(t, err)
+	decoded, err := decodeFeature(raw)
+	assert.Empty(t, err)
+	assert.Equal(t, fe, decoded)
+
+	// 旧格式
+	fe.Blob = []byte("blob")
+	decoded, err = decodeFeature(encodeV1(fe))
+	assert.Empty(t, err)
+	assert.Equal(t, fe, decoded)
+
+	raw, err = encodeFeature(fe)
+	assert.Empty(t, err)
+	for i := 0; i < len(raw); i++ {
+		_, err = decodeFeature(raw[:i])
+		assert.NotEmpty(t, err)
+	}
+	raw[4] = 3
+	_, err = decodeFeature(raw)
+	assert.NotEmpty(t, err)
+}
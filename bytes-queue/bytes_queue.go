@@ -3,13 +3,28 @@ package bytesqueue
 import (
 	"encoding/binary"
 	"errors"
-	"log"
+	"io"
+	"strconv"
 	"time"
 )
 
 const (
-	// Number of bytes to encode 0 in uvarint format
-	minimumHeaderSize = 17 // 1 byte blobsize + timestampSizeInBytes + hashSizeInBytes
+	// Number of bytes to encode timestamp of entry
+	timestampSizeInBytes = 8
+	// Number of bytes to encode hash of entry
+	hashSizeInBytes = 8
+	// Number of bytes to encode generation of entry
+	generationSizeInBytes = 3
+	// Number of bits of offset of entry in index, bytes array never grows beyond 1<<indexOffsetBits bytes,
+	// which is 1 TiB on 64-bit platforms and 16 MiB on 32-bit platforms
+	indexOffsetBits = strconv.IntSize/2 + 8
+	maxArraySize    = 1 << indexOffsetBits
+	// Number of bits of generation of entry, which is kept in the highest bits of index below the sign bit,
+	// 23 bits on 64-bit platforms and 7 bits on 32-bit platforms
+	generationBits = strconv.IntSize - 1 - indexOffsetBits
+	// Flags kept in the lowest bits of the first uvarint of header of entry
+	headerFlagBits = 1
+	headerFlagMeta = 1
 	// Bytes before left margin are not used. Zero index means element does not exist in queue, useful while reading slice from index
 	leftMarginIndex = 1
 )
@@ -19,6 +34,11 @@ var (
 	ErrInvalidIndex     = errors.New("Index must be greater than zero, invalid index.")
 	ErrIndexOutOfBounds = errors.New("Index out of range")
 	ErrFullQueue        = errors.New("Full queue. Maximum size limit reached.")
+	ErrEntryNotFound    = errors.New("Entry not found at index, it may have been popped or overwritten.")
+	ErrConcurrentModify = errors.New("Queue has been modified during iteration")
+	ErrInvalidSnapshot  = errors.New("Invalid snapshot")
+	ErrShrinkTooSmall   = errors.New("Capacity is too small to keep all entries")
+	ErrShrinkMoves      = errors.New("Entries have to be moved to shrink, which invalidates their indexes")
 )
 
 // BytesQueue is a non-thread-safe queue type of fifo based on bytes array.
@@ -33,40 +53,76 @@ type BytesQueue struct {
 	rightMarginIndex  uint64
 	count             int
 	headerEntryBuffer []byte
-	verbose           bool
+	logger            Logger
+
+	// generation is increased on every push and kept in header of the pushed entry and in its index,
+	// so an index of a popped entry does not match the entry overwriting it
+	generation uint64
+	// modCount is increased on every modification, used by Iterator to detect concurrent modification
+	modCount uint64
+	// storage allocates bytes array
+	storage storage
+	// reallocs and reallocTime count reallocation of bytes array
+	reallocs    int
+	reallocTime time.Duration
 }
 
 // getUvarintSize returns the number of bytes to encode x in uvarint format.
-func getUvarintSize(x uint32) uint64 {
-	if x < 128 {
-		return 1
-	} else if x < 16384 { // 128 * 128
-		return 2
-	} else if x < 2097152 { // 128 * 128 * 128
-		return 3
-	} else if x < 268435456 { // 128 * 128 * 128 * 128
-		return 4
-	} else {
-		return 5
+func getUvarintSize(x uint64) uint64 {
+	size := uint64(1)
+	for ; x >= 128; x >>= 7 {
+		size++
+	}
+	return size
+}
+
+// getHeaderEntrySize returns the number of bytes to encode header of entry of size of len.
+func getHeaderEntrySize(len uint64, withMeta bool) uint64 {
+	if withMeta {
+		return getUvarintSize(len<<headerFlagBits|headerFlagMeta) + generationSizeInBytes + timestampSizeInBytes + hashSizeInBytes
 	}
+	return getUvarintSize(len<<headerFlagBits) + generationSizeInBytes
+}
+
+// packIndex returns index of entry at offset of bytes array with generation.
+func packIndex(offset uint64, generation uint64) int {
+	return int(generation<<indexOffsetBits | offset)
+}
+
+// unpackIndex returns offset and generation of entry kept in index.
+func unpackIndex(index int) (uint64, uint64) {
+	if index <= 0 {
+		return 0, 0
+	}
+	return uint64(index) & (maxArraySize - 1), uint64(index) >> indexOffsetBits
 }
 
 // NewBytesQueue initializes a new bytes-queue.
 // Capacity is used in bytes array allocation.
-// When verbose flag is set then information about memory allocation will be printed.
-// TODO: 将capacity和maxCapacity设为4KB的倍数值.
+// When verbose flag is set then information about memory allocation will be logged by zerolog, see SetLogger.
+// Use NewMmapBytesQueue or OpenFileBytesQueue for page-aligned bytes array outside the Go heap.
 func NewBytesQueue(capacity int, maxCapacity int, verbose bool) *BytesQueue {
+	return newBytesQueue(heapStorage{}, make([]byte, capacity), maxCapacity, verbose)
+}
+
+func newBytesQueue(storage storage, array []byte, maxCapacity int, verbose bool) *BytesQueue {
+	capacity := len(array)
+	var logger Logger
+	if verbose {
+		logger = newVerboseLogger()
+	}
 	return &BytesQueue{
 		full:              false,
-		array:             make([]byte, capacity),
+		array:             array,
 		capacity:          uint64(capacity),
 		maxCapacity:       uint64(maxCapacity),
 		head:              leftMarginIndex,
 		tail:              leftMarginIndex,
 		rightMarginIndex:  leftMarginIndex,
 		count:             0,
-		headerEntryBuffer: make([]byte, binary.MaxVarintLen32),
-		verbose:           verbose,
+		headerEntryBuffer: make([]byte, binary.MaxVarintLen64+generationSizeInBytes+timestampSizeInBytes+hashSizeInBytes),
+		logger:            logger,
+		storage:           storage,
 	}
 }
 
@@ -78,77 +134,214 @@ func (q *BytesQueue) Reset() {
 	q.rightMarginIndex = leftMarginIndex
 	q.count = 0
 	q.full = false
+	q.modCount++
 }
 
 // Push copies entry at the end of bytes-queue and moves tail pointer.
 // Allocates more space if needed.
 // Returns index of pushed data or error if maximum queue size limit is reached.
 func (q *BytesQueue) Push(data []byte) (int, error) {
+	return q.pushEntry(data, false, 0, 0)
+}
+
+// PushEntry is identical to Push, but keeps timestamp and hash in header of entry,
+// which can be read back by PeekEntry, PopEntry or GetEntry.
+// Timestamp is expected in unix nanoseconds if entry is going to be evicted by EvictOlderThan.
+func (q *BytesQueue) PushEntry(data []byte, timestamp int64, hash uint64) (int, error) {
+	return q.pushEntry(data, true, timestamp, hash)
+}
+
+func (q *BytesQueue) pushEntry(data []byte, withMeta bool, timestamp int64, hash uint64) (int, error) {
 	dataLen := uint64(len(data))
-	headerEntrySize := getUvarintSize(uint32(dataLen))
+	headerEntrySize := getHeaderEntrySize(dataLen, withMeta)
 
-	if !q.canInsertAfterTail(dataLen + headerEntrySize) {
-		if q.canInsertBeforeHead(dataLen + headerEntrySize) {
-			q.tail = leftMarginIndex
-		} else if q.capacity+dataLen+headerEntrySize >= q.maxCapacity && q.maxCapacity > 0 {
-			return -1, ErrFullQueue
-		} else {
-			q.allocateAdditionalMemory(dataLen + headerEntrySize)
-		}
+	if err := q.reserve(dataLen + headerEntrySize); err != nil {
+		return -1, err
 	}
 
-	index := q.tail
+	index := packIndex(q.tail, q.nextGeneration())
+
+	q.push(q.header(dataLen, withMeta, q.generation, timestamp, hash), data, dataLen)
 
-	q.push(data, dataLen)
+	return index, nil
+}
 
-	return int(index), nil
+// PushBatch copies entries at the end of bytes-queue one after another.
+// Space for all of them is reserved at once, so either all entries are pushed or none is.
+// Returns indexes of pushed entries or error if maximum queue size limit is reached.
+func (q *BytesQueue) PushBatch(entries [][]byte) ([]int, error) {
+	need := uint64(0)
+	for _, data := range entries {
+		need += uint64(len(data)) + getHeaderEntrySize(uint64(len(data)), false)
+	}
+	if err := q.reserve(need); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, 0, len(entries))
+	for _, data := range entries {
+		indexes = append(indexes, packIndex(q.tail, q.nextGeneration()))
+		q.push(q.header(uint64(len(data)), false, q.generation, 0, 0), data, uint64(len(data)))
+	}
+	return indexes, nil
+}
+
+// reserve makes sure there are need bytes in a row at tail, which may move tail before head or allocate more space.
+func (q *BytesQueue) reserve(need uint64) error {
+	if !q.canInsertAfterTail(need) {
+		if q.canInsertBeforeHead(need) {
+			q.tail = leftMarginIndex
+		} else if q.capacity+need >= q.maxCapacity && q.maxCapacity > 0 || q.capacity+need > maxArraySize {
+			return ErrFullQueue
+		} else {
+			if err := q.allocateAdditionalMemory(need); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func (q *BytesQueue) allocateAdditionalMemory(minimum uint64) {
+func (q *BytesQueue) allocateAdditionalMemory(minimum uint64) error {
 	start := time.Now()
 
-	if q.capacity < minimum {
-		q.capacity += minimum
+	capacity := q.capacity
+	if capacity < minimum {
+		capacity += minimum
+	}
+	capacity = capacity * 2
+	if capacity > q.maxCapacity && q.maxCapacity > 0 {
+		capacity = q.maxCapacity
 	}
-	q.capacity = q.capacity * 2
-	if q.capacity > q.maxCapacity && q.maxCapacity > 0 {
-		q.capacity = q.maxCapacity
+	if capacity > maxArraySize {
+		capacity = maxArraySize
+	}
+	array, err := q.storage.alloc(capacity)
+	if err != nil {
+		return err
 	}
+	oldCapacity := q.capacity
+	q.capacity = uint64(len(array))
 
 	oldArray := q.array
-	q.array = make([]byte, q.capacity)
-
-	if leftMarginIndex != q.rightMarginIndex {
+	q.array = array
+
+	if q.count > 0 && q.tail <= q.head {
+		// entries after wrap-around are kept in place, while the older ones from head to right margin index
+		// are moved to the end of the new array, so entries stay in push order from head to tail
+		copy(q.array, oldArray[:q.tail])
+		head := q.capacity - (q.rightMarginIndex - q.head)
+		copy(q.array[head:], oldArray[q.head:q.rightMarginIndex])
+		q.head = head
+		q.rightMarginIndex = q.capacity
+	} else if leftMarginIndex != q.rightMarginIndex {
 		copy(q.array, oldArray[:q.rightMarginIndex])
+	}
 
-		if q.tail <= q.head {
-			if q.tail != q.head {
-				headerEntrySize := getUvarintSize(uint32(q.head - q.tail))
-				emptyBlobLen := q.head - q.tail - headerEntrySize
-				q.push(make([]byte, emptyBlobLen), emptyBlobLen)
-			}
+	q.full = false
+	q.storage.free(oldArray) // nolint
+
+	q.onRealloc(oldCapacity, start)
+	return nil
+}
 
+// Shrink reallocates bytes-queue down to capacity, e.g. after a traffic spike has passed.
+// If every entry lies below capacity, entries are kept in place and their indexes stay valid.
+// Otherwise entries are compacted to the beginning of the new array and onRemap is called with old and new index of each one,
+// or ErrShrinkMoves is returned if onRemap is nil, which means outstanding indexes must stay valid.
+// Returns ErrShrinkTooSmall if entries do not fit in capacity, bytes-queue is left untouched on error.
+// Capacity is never shrunk below leftMarginIndex.
+func (q *BytesQueue) Shrink(capacity int, onRemap func(oldIndex int, newIndex int)) error {
+	if capacity < leftMarginIndex {
+		capacity = leftMarginIndex
+	}
+	newCapacity := uint64(capacity)
+	if newCapacity >= q.capacity {
+		return nil
+	}
+	start := time.Now()
+
+	end := q.rightMarginIndex
+	if q.tail > end {
+		end = q.tail
+	}
+	if q.count == 0 {
+		end = 0
+	}
+	array, err := q.storage.alloc(newCapacity)
+	if err != nil {
+		return err
+	}
+	if uint64(len(array)) >= q.capacity {
+		// nothing to shrink after aligning capacity
+		q.storage.free(array) // nolint
+		return nil
+	}
+	newCapacity = uint64(len(array))
+	oldCapacity := q.capacity
+
+	if end <= newCapacity {
+		// keep entries in place
+		copy(array, q.array[:newCapacity])
+		q.storage.free(q.array) // nolint
+		q.array = array
+		if q.count == 0 {
 			q.head = leftMarginIndex
-			q.tail = q.rightMarginIndex
+			q.tail = leftMarginIndex
+			q.rightMarginIndex = leftMarginIndex
+		}
+	} else {
+		if onRemap == nil {
+			q.storage.free(array) // nolint
+			return ErrShrinkMoves
 		}
-	}
 
-	q.full = false
+		// generations are kept in headers of the moved entries
+		tail := uint64(leftMarginIndex)
+		var remaps [][2]int
+		it := q.Iterator()
+		for it.Next() {
+			size := it.pos - it.offset
+			if tail+size > newCapacity {
+				q.storage.free(array) // nolint
+				return ErrShrinkTooSmall
+			}
+			copy(array[tail:], q.array[it.offset:it.pos])
+			remaps = append(remaps, [2]int{it.index, packIndex(tail, it.generation)})
+			tail += size
+		}
+		defer func() {
+			for _, remap := range remaps {
+				onRemap(remap[0], remap[1])
+			}
+		}()
 
-	if q.verbose {
-		log.Printf("Allocated new bytes-queue in %s; Capacity: %d \n", time.Since(start), q.capacity)
+		q.storage.free(q.array) // nolint
+		q.array = array
+		q.head = leftMarginIndex
+		q.tail = tail
+		q.rightMarginIndex = tail
+		q.count = len(remaps)
+		q.full = false
 	}
+
+	q.capacity = newCapacity
+	q.modCount++
+
+	q.onRealloc(oldCapacity, start)
+	return nil
 }
 
 /*
-	...| HeaderEntrySize of e(i) | e(i) | HeaderEntrySize of e(i+1) | e(i+1) | ...
+	...| HeaderEntry of e(i) | e(i) | HeaderEntry of e(i+1) | e(i+1) | ...
 
-	HeaderEntrySize is the uvarint encoding of len of entry.
+	HeaderEntry is the uvarint encoding of len of entry shifted left by one bit,
+	the lowest bit tells whether 8 bytes timestamp and 8 bytes hash in little endian follow.
+	3 bytes generation in little endian follow the uvarint, before timestamp and hash.
 */
-func (q *BytesQueue) push(data []byte, len uint64) {
-	headerEntrySize := uint64(binary.PutUvarint(q.headerEntryBuffer, len))
-	// put the len of data first
-	q.copy(q.headerEntryBuffer, headerEntrySize)
+func (q *BytesQueue) push(header []byte, data []byte, len uint64) {
+	// put the header first
+	q.copy(header, uint64(cap(header)))
 	// then, put the data
 	q.copy(data, len)
 
@@ -161,22 +354,84 @@ func (q *BytesQueue) push(data []byte, len uint64) {
 	}
 
 	q.count++
+	q.modCount++
+}
+
+// header encodes header of entry into headerEntryBuffer.
+func (q *BytesQueue) header(len uint64, withMeta bool, generation uint64, timestamp int64, hash uint64) []byte {
+	x := len << headerFlagBits
+	if withMeta {
+		x |= headerFlagMeta
+	}
+	n := binary.PutUvarint(q.headerEntryBuffer, x)
+	for i := 0; i < generationSizeInBytes; i++ {
+		q.headerEntryBuffer[n+i] = byte(generation >> (8 * i))
+	}
+	n += generationSizeInBytes
+	if withMeta {
+		binary.LittleEndian.PutUint64(q.headerEntryBuffer[n:], uint64(timestamp))
+		binary.LittleEndian.PutUint64(q.headerEntryBuffer[n+timestampSizeInBytes:], hash)
+		n += timestampSizeInBytes + hashSizeInBytes
+	}
+	return q.headerEntryBuffer[:n:n]
+}
+
+// nextGeneration increases generation for the entry to be pushed.
+func (q *BytesQueue) nextGeneration() uint64 {
+	q.generation = (q.generation + 1) & (1<<generationBits - 1)
+	return q.generation
 }
 
 func (q *BytesQueue) copy(data []byte, len uint64) {
 	q.tail += uint64(copy(q.array[q.tail:], data[:len]))
 }
 
-// Pop reads the oldest entry from bytes-queue and moves head pointer to the next one.
+// Pop reads a copy of the oldest entry from bytes-queue and moves head pointer to the next one.
 func (q *BytesQueue) Pop() ([]byte, error) {
-	data, headerEntrySize, err := q.peek(q.head)
+	data, _, _, err := q.PopEntry()
+	return data, err
+}
+
+// PopEntry is identical to Pop, but also returns timestamp and hash of entry.
+// Timestamp and hash are zero if entry is pushed by Push.
+func (q *BytesQueue) PopEntry() ([]byte, int64, uint64, error) {
+	data, timestamp, hash, err := q.popEntry()
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, err
+	}
+	return append([]byte(nil), data...), timestamp, hash, nil
+}
+
+// PopView is identical to Pop, but returns the oldest entry without copying it.
+// Returned slice aliases bytes array of bytes-queue, it stays valid until the next modification of bytes-queue only.
+func (q *BytesQueue) PopView() ([]byte, error) {
+	data, _, _, err := q.popEntry()
+	return data, err
+}
+
+// PopInto copies the oldest entry into dst and moves head pointer to the next one.
+// Returns number of copied bytes, or io.ErrShortBuffer without popping if dst is too small for the entry.
+func (q *BytesQueue) PopInto(dst []byte) (int, error) {
+	data, err := q.Peek()
+	if err != nil {
+		return 0, err
+	}
+	if len(dst) < len(data) {
+		return 0, io.ErrShortBuffer
+	}
+	q.popEntry() // nolint
+	return copy(dst, data), nil
+}
+
+func (q *BytesQueue) popEntry() ([]byte, int64, uint64, error) {
+	e, err := q.peek(q.head)
+	if err != nil {
+		return nil, 0, 0, err
 	}
-	size := uint64(len(data))
 
-	q.head += headerEntrySize + size
+	q.head += e.size
 	q.count--
+	q.modCount++
 
 	// deal with empty bytes-queue
 	if q.head == q.rightMarginIndex {
@@ -189,24 +444,92 @@ func (q *BytesQueue) Pop() ([]byte, error) {
 
 	q.full = false
 
-	return data, nil
+	return e.data, e.timestamp, e.hash, nil
+}
+
+// EvictOlderThan pops entries from the head of bytes-queue while they are older than d,
+// and calls onEvict (if not nil) for each one. Data passed to onEvict must not be retained after it returns.
+// It stops at the first entry pushed without timestamp, returns number of evicted entries.
+func (q *BytesQueue) EvictOlderThan(d time.Duration, onEvict func(data []byte)) int {
+	deadline := time.Now().Add(-d).UnixNano()
+	evicted := 0
+	for {
+		data, timestamp, _, err := q.PeekEntry()
+		if err != nil || timestamp == 0 || timestamp >= deadline {
+			break
+		}
+		q.popEntry() // nolint
+		evicted++
+		if onEvict != nil {
+			onEvict(data)
+		}
+	}
+	return evicted
 }
 
 // Peek reads the oldest entry from bytes-queue without moving head pointer.
+// Returned slice aliases bytes array of bytes-queue, it stays valid until the next modification of bytes-queue only.
 func (q *BytesQueue) Peek() ([]byte, error) {
-	data, _, err := q.peek(q.head)
-	return data, err
+	e, err := q.peek(q.head)
+	return e.data, err
+}
+
+// PeekEntry is identical to Peek, but also returns timestamp and hash of entry.
+// Timestamp and hash are zero if entry is pushed by Push.
+func (q *BytesQueue) PeekEntry() ([]byte, int64, uint64, error) {
+	e, err := q.peek(q.head)
+	return e.data, e.timestamp, e.hash, err
 }
 
 // Get reads entry at index from bytes-queue.
+// Returned slice aliases bytes array of bytes-queue, it stays valid until the next modification of bytes-queue only.
+// Returns ErrEntryNotFound if index does not point at an entry kept in bytes-queue.
+// Index keeps the generation of entry besides its offset, so an index of a popped entry is not taken for the entry
+// overwriting it, unless a multiple of 1<<23 (1<<7 on 32-bit platforms) entries have been pushed in between.
+// An index pointing in the middle of an entry, which is never returned by bytes-queue, is detected in the same way.
+// Growing bytes-queue after wrap-around moves the entries pushed before wrap-around, Get returns ErrEntryNotFound
+// for their indexes as well.
 func (q *BytesQueue) Get(index int) ([]byte, error) {
-	data, _, err := q.peek(uint64(index))
+	data, _, _, err := q.GetEntry(index)
 	return data, err
 }
 
-// CheckGet checks if an entry can be read at index.
+// GetEntry is identical to Get, but also returns timestamp and hash of entry.
+// Timestamp and hash are zero if entry is pushed by Push.
+func (q *BytesQueue) GetEntry(index int) ([]byte, int64, uint64, error) {
+	e, err := q.locate(index)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return e.data, e.timestamp, e.hash, nil
+}
+
+// CheckGet checks if an entry can be read at index, see Get.
 func (q *BytesQueue) CheckGet(index int) error {
-	return q.peekCheckErr(uint64(index))
+	_, err := q.locate(index)
+	return err
+}
+
+// locate returns entry at index, or ErrEntryNotFound if index does not point at an entry kept in bytes-queue.
+func (q *BytesQueue) locate(index int) (entry, error) {
+	offset, generation := unpackIndex(index)
+	if err := q.peekCheckErr(offset); err != nil {
+		return entry{}, err
+	}
+
+	// entries are kept in [head, tail), or in [head, rightMarginIndex) and [leftMarginIndex, tail) after wrap-around
+	end := q.tail
+	if q.tail <= q.head && offset >= q.head {
+		end = q.rightMarginIndex
+	}
+	if q.tail > q.head && offset < q.head || offset >= end {
+		return entry{}, ErrEntryNotFound
+	}
+	e, ok := q.checkEntry(offset, end)
+	if !ok || e.generation != generation {
+		return entry{}, ErrEntryNotFound
+	}
+	return e, nil
 }
 
 // Capacity returns number of allocated bytes for bytes-queue.
@@ -230,21 +553,61 @@ func (q *BytesQueue) peekCheckErr(index uint64) error {
 	if index >= uint64(len(q.array)) {
 		return ErrIndexOutOfBounds
 	}
-	// TODO: maybe we can use bloom filter to record every valid index, and check the index in more detail.
 	return nil
 }
 
-// peek returns the data at index and the number of bytes to encode the length of the data in uvarint format.
-func (q *BytesQueue) peek(index uint64) ([]byte, uint64, error) {
+// entry is an entry decoded from bytes array together with its header.
+type entry struct {
+	data       []byte
+	timestamp  int64
+	hash       uint64
+	generation uint64
+	// size is the number of bytes of the entry including its header
+	size uint64
+}
+
+// peek returns the entry at index, which must be the start of an entry.
+func (q *BytesQueue) peek(index uint64) (entry, error) {
 	if err := q.peekCheckErr(index); err != nil {
-		return nil, 0, err
+		return entry{}, err
 	}
+	return q.readEntry(index), nil
+}
 
-	// blockSize is the length of the entry
-	// n is the number of bytes to encode the length of the entry in uvarint format
-	blockSize, n := binary.Uvarint(q.array[index:])
-	un := uint64(n)
-	return q.array[index+un : index+un+blockSize], un, nil
+// readEntry decodes the entry at index, which must be the start of an entry.
+func (q *BytesQueue) readEntry(index uint64) entry {
+	e, _ := q.checkEntry(index, uint64(len(q.array)))
+	return e
+}
+
+// checkEntry decodes the entry at index, returns false if it does not fit in bytes array before end.
+func (q *BytesQueue) checkEntry(index uint64, end uint64) (entry, bool) {
+	x, n := binary.Uvarint(q.array[index:end])
+	if n <= 0 {
+		return entry{}, false
+	}
+	// header is the number of bytes to encode the header of the entry
+	header := uint64(n) + generationSizeInBytes
+	if x&headerFlagMeta != 0 {
+		header += timestampSizeInBytes + hashSizeInBytes
+	}
+	blockSize := x >> headerFlagBits
+	if header > end-index || blockSize > end-index-header {
+		return entry{}, false
+	}
+	var e entry
+	pos := index + uint64(n)
+	for i := 0; i < generationSizeInBytes; i++ {
+		e.generation |= uint64(q.array[pos+uint64(i)]) << (8 * i)
+	}
+	if x&headerFlagMeta != 0 {
+		pos += generationSizeInBytes
+		e.timestamp = int64(binary.LittleEndian.Uint64(q.array[pos:]))
+		e.hash = binary.LittleEndian.Uint64(q.array[pos+timestampSizeInBytes:])
+	}
+	e.data = q.array[index+header : index+header+blockSize]
+	e.size = header + blockSize
+	return e, true
 }
 
 // canInsertAfterTail returns true if it's possible to insert an entry of size of need at the tail of the queue.
@@ -255,11 +618,7 @@ func (q *BytesQueue) canInsertAfterTail(need uint64) bool {
 	if q.tail >= q.head {
 		return q.capacity-q.tail >= need
 	}
-	// 1. there is exactly need bytes between head and tail, so we do not need
-	// to reserve extra space for a potential empty entry when realloc this queue
-	// 2. still have unused space between tail and head, then we must reserve
-	// at least headerEntrySize bytes so we can put an empty entry
-	return q.head-q.tail == need || q.head-q.tail >= need+minimumHeaderSize
+	return q.head-q.tail >= need
 }
 
 // canInsertBeforeHead returns true if it's possible to insert an entry of size of need before the head of the queue.
@@ -268,7 +627,7 @@ func (q *BytesQueue) canInsertBeforeHead(need uint64) bool {
 		return false
 	}
 	if q.tail >= q.head {
-		return q.head-leftMarginIndex == need || q.head-leftMarginIndex >= need+minimumHeaderSize
+		return q.head-leftMarginIndex >= need
 	}
-	return q.head-q.tail == need || q.head-q.tail >= need+minimumHeaderSize
+	return q.head-q.tail >= need
 }
@@ -0,0 +1,377 @@
+package bytesqueue
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBytesQueuePackIndex(t *testing.T) {
+	// index is a positive int on both 32-bit and 64-bit platforms
+	maxGeneration := uint64(1<<generationBits - 1)
+	index := packIndex(maxArraySize-1, maxGeneration)
+	assert.True(t, index > 0)
+	offset, generation := unpackIndex(index)
+	assert.Equal(t, uint64(maxArraySize-1), offset)
+	assert.Equal(t, maxGeneration, generation)
+}
+
+func TestBytesQueueGetInvalidIndex(t *testing.T) {
+	q := NewBytesQueue(16, 0, false)
+
+	i1, err := q.Push([]byte("hello"))
+	assert.Empty(t, err)
+	i2, err := q.Push([]byte("world"))
+	assert.Empty(t, err)
+
+	data, err := q.Get(i2)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("world"), data)
+
+	// index in the middle of an entry
+	_, err = q.Get(i2 + 1)
+	assert.Equal(t, ErrEntryNotFound, err)
+	assert.Equal(t, ErrEntryNotFound, q.CheckGet(i2+1))
+
+	// popped entry
+	_, err = q.Pop()
+	assert.Empty(t, err)
+	_, err = q.Get(i1)
+	assert.Equal(t, ErrEntryNotFound, err)
+
+	// index of an entry with another generation
+	offset, generation := unpackIndex(i2)
+	_, err = q.Get(packIndex(offset, generation+1))
+	assert.Equal(t, ErrEntryNotFound, err)
+
+	// entries survive reallocation, the empty entry filled in does not
+	for i := 0; i < 8; i++ {
+		_, err = q.Push([]byte("0123456789"))
+		assert.Empty(t, err)
+	}
+	data, err = q.Get(i2)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("world"), data)
+
+	q.Reset()
+	_, err = q.Get(i2)
+	assert.Equal(t, ErrEmptyQueue, err)
+	_, err = q.Push([]byte("again"))
+	assert.Empty(t, err)
+	_, err = q.Get(i2)
+	assert.Equal(t, ErrEntryNotFound, err)
+}
+
+func TestBytesQueueGetAfterWrapAround(t *testing.T) {
+	q := NewBytesQueue(64, 64, false)
+
+	var indexes []int
+	for i := 0; i < 3; i++ {
+		index, err := q.Push(make([]byte, 17))
+		assert.Empty(t, err)
+		indexes = append(indexes, index)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := q.Pop()
+		assert.Empty(t, err)
+	}
+
+	// the new entry overwrites the popped ones at the same offset, but with another index
+	index, err := q.Push([]byte("01234567890123456"))
+	assert.Empty(t, err)
+	offset, _ := unpackIndex(index)
+	oldOffset, _ := unpackIndex(indexes[0])
+	assert.Equal(t, oldOffset, offset)
+	assert.NotEqual(t, indexes[0], index)
+	data, err := q.Get(index)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("01234567890123456"), data)
+	_, err = q.Get(indexes[0])
+	assert.Equal(t, ErrEntryNotFound, err)
+	_, err = q.Get(indexes[1])
+	assert.Equal(t, ErrEntryNotFound, err)
+	_, err = q.Get(indexes[2])
+	assert.Empty(t, err)
+}
+
+func TestBytesQueueGetAfterPopAll(t *testing.T) {
+	q := NewBytesQueue(16, 0, false)
+
+	// popping the last entry moves head and tail back to left margin index
+	i1, err := q.Push([]byte("aaaa"))
+	assert.Empty(t, err)
+	_, err = q.Pop()
+	assert.Empty(t, err)
+	i2, err := q.Push([]byte("bbbb"))
+	assert.Empty(t, err)
+
+	_, err = q.Get(i1)
+	assert.Equal(t, ErrEntryNotFound, err)
+	data, err := q.Get(i2)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("bbbb"), data)
+}
+
+func TestBytesQueueEntryHeader(t *testing.T) {
+	q := NewBytesQueue(16, 0, false)
+
+	i1, err := q.PushEntry([]byte("hello"), 1234567890, 0xdeadbeef)
+	assert.Empty(t, err)
+	i2, err := q.Push([]byte("world"))
+	assert.Empty(t, err)
+	i3, err := q.PushEntry(make([]byte, 200), -1, 42)
+	assert.Empty(t, err)
+
+	data, timestamp, hash, err := q.PeekEntry()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	assert.Equal(t, int64(1234567890), timestamp)
+	assert.Equal(t, uint64(0xdeadbeef), hash)
+
+	data, timestamp, hash, err = q.GetEntry(i2)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("world"), data)
+	assert.Equal(t, int64(0), timestamp)
+	assert.Equal(t, uint64(0), hash)
+
+	data, timestamp, hash, err = q.GetEntry(i3)
+	assert.Empty(t, err)
+	assert.Equal(t, 200, len(data))
+	assert.Equal(t, int64(-1), timestamp)
+	assert.Equal(t, uint64(42), hash)
+
+	data, timestamp, hash, err = q.PopEntry()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	assert.Equal(t, int64(1234567890), timestamp)
+	_, err = q.Get(i1)
+	assert.Equal(t, ErrEntryNotFound, err)
+	data, err = q.Pop()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("world"), data)
+}
+
+func TestBytesQueueEvictOlderThan(t *testing.T) {
+	q := NewBytesQueue(64, 0, false)
+
+	now := time.Now()
+	_, err := q.PushEntry([]byte("a"), now.Add(-3*time.Minute).UnixNano(), 0)
+	assert.Empty(t, err)
+	_, err = q.PushEntry([]byte("b"), now.Add(-2*time.Minute).UnixNano(), 0)
+	assert.Empty(t, err)
+	_, err = q.PushEntry([]byte("c"), now.UnixNano(), 0)
+	assert.Empty(t, err)
+	_, err = q.Push([]byte("d"))
+	assert.Empty(t, err)
+
+	var evicted []string
+	onEvict := func(data []byte) {
+		evicted = append(evicted, string(data))
+	}
+	assert.Equal(t, 2, q.EvictOlderThan(time.Minute, onEvict))
+	assert.Equal(t, []string{"a", "b"}, evicted)
+	assert.Equal(t, 2, q.Len())
+
+	// stops at the entry pushed without timestamp
+	assert.Equal(t, 1, q.EvictOlderThan(0, onEvict))
+	assert.Equal(t, 0, q.EvictOlderThan(0, onEvict))
+	data, err := q.Peek()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("d"), data)
+}
+
+func TestBytesQueueEvictOlderThanAfterGrowth(t *testing.T) {
+	// room for 5 entries of 22 bytes
+	q := NewBytesQueue(111, 0, false)
+
+	// e0..e8 are older than a minute, e9..e11 are not
+	now := time.Now()
+	push := func(i int) {
+		timestamp := now.Add(time.Duration(i-9) * time.Minute).UnixNano()
+		_, err := q.PushEntry([]byte(fmt.Sprintf("e%d", i)), timestamp, 0)
+		assert.Empty(t, err)
+	}
+	for i := 0; i < 5; i++ {
+		push(i)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := q.Pop()
+		assert.Empty(t, err)
+	}
+	// e5..e7 wrap around, e8 grows the queue
+	for i := 5; i < 12; i++ {
+		push(i)
+	}
+	assert.Equal(t, 1, q.Stats().Reallocations)
+
+	var evicted []string
+	assert.Equal(t, 6, q.EvictOlderThan(time.Minute, func(data []byte) {
+		evicted = append(evicted, string(data))
+	}))
+	assert.Equal(t, []string{"e3", "e4", "e5", "e6", "e7", "e8"}, evicted)
+	for i := 9; i < 12; i++ {
+		data, err := q.Pop()
+		assert.Empty(t, err)
+		assert.Equal(t, fmt.Sprintf("e%d", i), string(data))
+	}
+}
+
+func TestBytesQueueShrink(t *testing.T) {
+	q := NewBytesQueue(64, 0, false)
+	for i := 0; i < 100; i++ {
+		_, err := q.Push([]byte("0123456789"))
+		assert.Empty(t, err)
+	}
+	assert.True(t, q.Capacity() > 1000)
+
+	q.Reset()
+	assert.Empty(t, q.Shrink(0, nil))
+	assert.Equal(t, leftMarginIndex, q.Capacity())
+
+	// entries below capacity stay in place
+	var indexes []int
+	for i := 0; i < 10; i++ {
+		index, err := q.Push([]byte("0123456789"))
+		assert.Empty(t, err)
+		indexes = append(indexes, index)
+	}
+	assert.Empty(t, q.Shrink(160, nil))
+	assert.Equal(t, 160, q.Capacity())
+	for _, index := range indexes {
+		data, err := q.Get(index)
+		assert.Empty(t, err)
+		assert.Equal(t, []byte("0123456789"), data)
+	}
+
+	// entries have to be moved
+	for i := 0; i < 8; i++ {
+		_, err := q.Pop()
+		assert.Empty(t, err)
+	}
+	assert.Equal(t, ErrShrinkMoves, q.Shrink(32, nil))
+	assert.Equal(t, ErrShrinkTooSmall, q.Shrink(16, func(int, int) {}))
+	assert.Equal(t, 160, q.Capacity())
+
+	remapped := make(map[int]int)
+	assert.Empty(t, q.Shrink(32, func(oldIndex int, newIndex int) {
+		remapped[oldIndex] = newIndex
+	}))
+	assert.Equal(t, 32, q.Capacity())
+	assert.Equal(t, 2, len(remapped))
+	for i, expected := range []uint64{1, 15} {
+		oldOffset, oldGeneration := unpackIndex(indexes[8+i])
+		offset, generation := unpackIndex(remapped[indexes[8+i]])
+		assert.NotEqual(t, oldOffset, offset)
+		assert.Equal(t, expected, offset)
+		assert.Equal(t, oldGeneration, generation)
+	}
+	for _, index := range remapped {
+		data, err := q.Get(index)
+		assert.Empty(t, err)
+		assert.Equal(t, []byte("0123456789"), data)
+	}
+	_, err := q.Push([]byte("abcdefg"))
+	assert.Empty(t, err)
+	assert.Equal(t, 3, q.Len())
+}
+
+func TestBytesQueuePushBatch(t *testing.T) {
+	q := NewBytesQueue(16, 64, false)
+
+	indexes, err := q.PushBatch([][]byte{[]byte("hello"), []byte("world"), []byte("0123456789")})
+	assert.Empty(t, err)
+	for i, expected := range []uint64{1, 10, 19} {
+		offset, _ := unpackIndex(indexes[i])
+		assert.Equal(t, expected, offset)
+	}
+	assert.Equal(t, 3, q.Len())
+	for i, expected := range []string{"hello", "world", "0123456789"} {
+		data, err := q.Get(indexes[i])
+		assert.Empty(t, err)
+		assert.Equal(t, []byte(expected), data)
+	}
+
+	// either all entries are pushed or none is
+	_, err = q.PushBatch([][]byte{make([]byte, 20), make([]byte, 20)})
+	assert.Equal(t, ErrFullQueue, err)
+	assert.Equal(t, 3, q.Len())
+}
+
+func TestBytesQueuePopInto(t *testing.T) {
+	q := NewBytesQueue(16, 0, false)
+	_, err := q.Push([]byte("hello"))
+	assert.Empty(t, err)
+	_, err = q.Push([]byte("world"))
+	assert.Empty(t, err)
+
+	dst := make([]byte, 4)
+	_, err = q.PopInto(dst)
+	assert.Equal(t, io.ErrShortBuffer, err)
+	assert.Equal(t, 2, q.Len())
+
+	dst = make([]byte, 8)
+	n, err := q.PopInto(dst)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), dst[:n])
+
+	// Pop returns a copy while PopView aliases bytes array
+	data, err := q.Pop()
+	assert.Empty(t, err)
+	_, err = q.Push([]byte("01234"))
+	assert.Empty(t, err)
+	view, err := q.PopView()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("world"), data)
+	assert.Equal(t, []byte("01234"), view)
+	_, err = q.Push([]byte("abcde"))
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("abcde"), view)
+
+	_, err = q.PopInto(dst)
+	assert.Empty(t, err)
+	_, err = q.PopInto(dst)
+	assert.Equal(t, ErrEmptyQueue, err)
+}
+
+func TestBytesQueuePopOrderAfterGrowth(t *testing.T) {
+	q := NewBytesQueue(64, 0, false)
+	var indexes []int
+	for _, data := range []string{"a", "b", "c"} {
+		index, err := q.Push(bytes.Repeat([]byte(data), 17))
+		assert.Empty(t, err)
+		indexes = append(indexes, index)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := q.Pop()
+		assert.Empty(t, err)
+	}
+	// wrap around, then reallocation moves the older entry to the end of the new array
+	index, err := q.Push(bytes.Repeat([]byte("d"), 17))
+	assert.Empty(t, err)
+	_, err = q.Push(bytes.Repeat([]byte("e"), 46))
+	assert.Empty(t, err)
+	assert.Equal(t, 3, q.Len())
+
+	// entries after wrap-around are kept in place
+	_, err = q.Get(indexes[2])
+	assert.Equal(t, ErrEntryNotFound, err)
+	data, err := q.Get(index)
+	assert.Empty(t, err)
+	assert.Equal(t, "d", string(data[:1]))
+
+	for _, expected := range []string{"c", "d", "e"} {
+		data, err := q.Peek()
+		assert.Empty(t, err)
+		assert.Equal(t, expected, string(data[:1]))
+		data, err = q.Pop()
+		assert.Empty(t, err)
+		assert.Equal(t, expected, string(data[:1]))
+	}
+	assert.Equal(t, 0, q.Len())
+	_, err = q.Pop()
+	assert.Equal(t, ErrEmptyQueue, err)
+}
@@ -0,0 +1,106 @@
+package bytesqueue
+
+import (
+	"bufio"
+	"errors"
+	"os"
+
+	"github.com/usherasnick/Useful-Go-Gadgets/fwriter"
+)
+
+const (
+	fileLayoutMagic = "BQMF"
+	// fileLayoutSuffix is the suffix of file keeping indexes of file-backed bytes-queue
+	fileLayoutSuffix = ".layout"
+)
+
+var (
+	ErrInvalidCapacity = errors.New("Capacity must be greater than zero")
+)
+
+// OpenFileBytesQueue opens a bytes-queue whose bytes array is a shared memory mapping of file fn,
+// so it lives outside the Go heap and can survive a restart.
+// On platforms without memory mapping, file fn is read into bytes array in the Go heap and written back by Sync.
+// Capacity is rounded up to a multiple of page size and never changes, Push returns ErrFullQueue once it is used up.
+// If fn has been synced before, entries and their indexes are restored as of the last Sync, and capacity is ignored.
+// Indexes are kept in file fn+".layout", which is replaced atomically by Sync.
+// Entries popped and overwritten after the last Sync are corrupted if the process crashes, call Close to sync on exit.
+func OpenFileBytesQueue(fn string, capacity int, verbose bool) (*BytesQueue, error) {
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := os.Open(fn + fileLayoutSuffix)
+	restore := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		f.Close() // nolint
+		return nil, err
+	}
+	if restore {
+		defer layout.Close() // nolint
+		info, err := f.Stat()
+		if err != nil {
+			f.Close() // nolint
+			return nil, err
+		}
+		capacity = int(info.Size())
+	}
+	size := alignToPage(uint64(capacity))
+	if size == 0 {
+		f.Close() // nolint
+		return nil, ErrInvalidCapacity
+	}
+	if err := f.Truncate(int64(size)); err != nil {
+		f.Close() // nolint
+		return nil, err
+	}
+
+	array, err := mapFile(f, size)
+	if err != nil {
+		f.Close() // nolint
+		return nil, err
+	}
+	q := newBytesQueue(&fileStorage{f: f, fn: fn}, array, int(size), verbose)
+	if restore {
+		_, err = q.readLayout(bufio.NewReader(layout), fileLayoutMagic, array)
+	} else {
+		err = q.Sync()
+	}
+	if err != nil {
+		unmapFile(array) // nolint
+		f.Close()        // nolint
+		return nil, err
+	}
+	return q, nil
+}
+
+// Sync flushes entries of file-backed bytes-queue to disk, then replaces its layout file atomically.
+// It does nothing for other bytes-queues.
+func (q *BytesQueue) Sync() error {
+	fs, ok := q.storage.(*fileStorage)
+	if !ok {
+		return nil
+	}
+	if err := flushFile(fs.f, q.array); err != nil {
+		return err
+	}
+	if err := fs.f.Sync(); err != nil {
+		return err
+	}
+
+	w, err := fwriter.NewSafeWriter(fs.fn + fileLayoutSuffix)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := q.writeLayout(bw, fileLayoutMagic, false); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Commit()
+}
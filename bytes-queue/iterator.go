@@ -0,0 +1,122 @@
+package bytesqueue
+
+// Iterator walks entries of bytes-queue from head to tail without popping them.
+// Any modification of bytes-queue during iteration stops the iterator with ErrConcurrentModify,
+// iterate over a copy built from Snapshot if bytes-queue may be modified meanwhile.
+type Iterator struct {
+	q         *BytesQueue
+	pos       uint64
+	remaining int
+	modCount  uint64
+
+	// offset and generation of the current entry
+	offset     uint64
+	generation uint64
+	index      int
+	data       []byte
+	err        error
+}
+
+// Iterator returns an iterator positioned before the oldest entry of bytes-queue.
+func (q *BytesQueue) Iterator() *Iterator {
+	return &Iterator{
+		q:         q,
+		pos:       q.head,
+		remaining: q.count,
+		modCount:  q.modCount,
+	}
+}
+
+// Next moves iterator to the next entry, returns false if there are no more entries or an error occurred.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.modCount != it.q.modCount {
+		it.err = ErrConcurrentModify
+		return false
+	}
+
+	if it.remaining > 0 {
+		// entries after right margin index have been written before head
+		if it.pos == it.q.rightMarginIndex {
+			it.pos = leftMarginIndex
+		}
+		e, err := it.q.peek(it.pos)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.offset = it.pos
+		it.generation = e.generation
+		it.index = packIndex(it.pos, e.generation)
+		it.data = e.data
+		it.pos += e.size
+		it.remaining--
+		return true
+	}
+	return false
+}
+
+// Index returns index of the current entry, which can be passed to Get.
+func (it *Iterator) Index() int {
+	return it.index
+}
+
+// Value returns data of the current entry, it must not be retained after bytes-queue is modified.
+func (it *Iterator) Value() []byte {
+	return it.data
+}
+
+// Err returns error which stopped iterator.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Snapshot copies out entries of bytes-queue from head to tail into a compacted byte image,
+// which keeps every entry with its header one after another, and can be restored by NewBytesQueueFromSnapshot.
+func (q *BytesQueue) Snapshot() []byte {
+	image := make([]byte, 0, q.usedBytes())
+	it := q.Iterator()
+	for it.Next() {
+		image = append(image, q.array[it.offset:it.pos]...)
+	}
+	return image
+}
+
+// NewBytesQueueFromSnapshot initializes a new bytes-queue with entries of image taken by Snapshot.
+// Indexes of entries are not kept, they can be read by Iterator.
+func NewBytesQueueFromSnapshot(image []byte, maxCapacity int, verbose bool) (*BytesQueue, error) {
+	capacity := leftMarginIndex + len(image)
+	if maxCapacity > 0 && capacity > maxCapacity {
+		return nil, ErrFullQueue
+	}
+
+	q := NewBytesQueue(capacity, maxCapacity, verbose)
+	q.tail += uint64(copy(q.array[leftMarginIndex:], image))
+	q.rightMarginIndex = q.tail
+	for index := uint64(leftMarginIndex); index < q.tail; {
+		e, ok := q.checkEntry(index, q.tail)
+		if !ok {
+			return nil, ErrInvalidSnapshot
+		}
+		q.count++
+		index += e.size
+		// generations of new entries do not restart from the ones of restored entries
+		if e.generation > q.generation {
+			q.generation = e.generation
+		}
+	}
+	return q, nil
+}
+
+// usedBytes returns number of bytes occupied by entries and their headers.
+func (q *BytesQueue) usedBytes() uint64 {
+	if q.count == 0 {
+		return 0
+	}
+	if q.tail > q.head {
+		return q.tail - q.head
+	}
+	return q.rightMarginIndex - q.head + q.tail - leftMarginIndex
+}
@@ -0,0 +1,108 @@
+package bytesqueue
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(t *testing.T, q *BytesQueue) []string {
+	var entries []string
+	it := q.Iterator()
+	for it.Next() {
+		data, err := q.Get(it.Index())
+		assert.Empty(t, err)
+		assert.Equal(t, data, it.Value())
+		entries = append(entries, string(it.Value()))
+	}
+	assert.Empty(t, it.Err())
+	return entries
+}
+
+func TestIterator(t *testing.T) {
+	q := NewBytesQueue(128, 128, false)
+	assert.Empty(t, collect(t, q))
+
+	for i := 0; i < 3; i++ {
+		_, err := q.Push(make([]byte, 38))
+		assert.Empty(t, err)
+	}
+	_, err := q.Pop()
+	assert.Empty(t, err)
+	_, err = q.Pop()
+	assert.Empty(t, err)
+	// wrap around
+	_, err = q.PushEntry([]byte("hello"), 1, 2)
+	assert.Empty(t, err)
+	_, err = q.Push([]byte("world"))
+	assert.Empty(t, err)
+	assert.Equal(t, []string{string(make([]byte, 38)), "hello", "world"}, collect(t, q))
+
+	it := q.Iterator()
+	assert.True(t, it.Next())
+	_, err = q.Pop()
+	assert.Empty(t, err)
+	assert.False(t, it.Next())
+	assert.Equal(t, ErrConcurrentModify, it.Err())
+}
+
+func TestIteratorAfterGrowth(t *testing.T) {
+	q := NewBytesQueue(64, 0, false)
+	for _, data := range []string{"a", "b", "c"} {
+		_, err := q.Push(bytes.Repeat([]byte(data), 17))
+		assert.Empty(t, err)
+	}
+	_, err := q.Pop()
+	assert.Empty(t, err)
+	_, err = q.Pop()
+	assert.Empty(t, err)
+	// wrap around
+	_, err = q.Push(bytes.Repeat([]byte("d"), 17))
+	assert.Empty(t, err)
+	// reallocation keeps entries in push order
+	_, err = q.Push(bytes.Repeat([]byte("e"), 46))
+	assert.Empty(t, err)
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, []string{
+		strings.Repeat("c", 17),
+		strings.Repeat("d", 17),
+		strings.Repeat("e", 46),
+	}, collect(t, q))
+}
+
+func TestSnapshot(t *testing.T) {
+	q := NewBytesQueue(128, 128, false)
+	for i := 0; i < 3; i++ {
+		_, err := q.Push(make([]byte, 38))
+		assert.Empty(t, err)
+	}
+	_, err := q.Pop()
+	assert.Empty(t, err)
+	_, err = q.PushEntry([]byte("hello"), 1, 2)
+	assert.Empty(t, err)
+
+	image := q.Snapshot()
+	assert.Equal(t, 2*42+1+3+16+5, len(image))
+
+	restored, err := NewBytesQueueFromSnapshot(image, 0, false)
+	assert.Empty(t, err)
+	assert.Equal(t, collect(t, q), collect(t, restored))
+	_, err = restored.Pop()
+	assert.Empty(t, err)
+	_, err = restored.Pop()
+	assert.Empty(t, err)
+	data, timestamp, hash, err := restored.PopEntry()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	assert.Equal(t, int64(1), timestamp)
+	assert.Equal(t, uint64(2), hash)
+	_, err = restored.Push([]byte("more"))
+	assert.Empty(t, err)
+
+	_, err = NewBytesQueueFromSnapshot(image[:len(image)-1], 0, false)
+	assert.Equal(t, ErrInvalidSnapshot, err)
+	_, err = NewBytesQueueFromSnapshot(image, 64, false)
+	assert.Equal(t, ErrFullQueue, err)
+}
@@ -0,0 +1,210 @@
+package bytesqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	persistMagic   = "BQUE"
+	persistVersion = 3
+	// magic + version + 6 uint64 fields + full flag + length of array
+	persistHeaderSize = len(persistMagic) + 1 + 8*6 + 1 + 8
+	// crc32 of header, checked before allocating bytes array
+	persistHeaderChecksumSize = 4
+)
+
+var (
+	ErrCorruptedQueue     = errors.New("Corrupted persisted queue")
+	ErrUnsupportedVersion = errors.New("Unsupported version of persisted queue")
+)
+
+/*
+	Persisted bytes-queue is laid out as below, integers are in little endian.
+
+	| magic "BQUE" | version uint8 | capacity uint64 | head uint64 | tail uint64 | rightMarginIndex uint64 |
+	| count uint64 | generation uint64 | full uint8 | n uint64 | crc32 of header uint32 | array[:n] | crc32 of all above uint32 |
+
+	n is the end of the used part of array, so indexes handed out before stay valid after restoring.
+	Header is checksummed on its own, so a corrupted capacity is detected before allocating bytes array.
+*/
+
+// WriteTo writes bytes-queue to w in a versioned and checksummed binary format.
+// Wrap a fwriter.SafeWriter to get a crash-safe checkpoint.
+func (q *BytesQueue) WriteTo(w io.Writer) (int64, error) {
+	return q.writeLayout(w, persistMagic, true)
+}
+
+// ReadFrom replaces entries of bytes-queue with the ones written by WriteTo, including the layout of them,
+// so indexes handed out before WriteTo can still be used. Maximum queue size limit of bytes-queue is kept.
+// Bytes-queue is left untouched if an error is returned.
+func (q *BytesQueue) ReadFrom(r io.Reader) (int64, error) {
+	return q.readLayout(r, persistMagic, nil)
+}
+
+// writeLayout writes indexes and optionally bytes array of bytes-queue to w.
+func (q *BytesQueue) writeLayout(w io.Writer, magic string, withArray bool) (int64, error) {
+	n := q.rightMarginIndex
+	if q.tail > n {
+		n = q.tail
+	}
+
+	cw := &crcWriter{w: w, crc: crc32.NewIEEE()}
+	header := make([]byte, persistHeaderSize)
+	copy(header, magic)
+	header[len(magic)] = persistVersion
+	fields := header[len(magic)+1:]
+	binary.LittleEndian.PutUint64(fields[0:], q.capacity)
+	binary.LittleEndian.PutUint64(fields[8:], q.head)
+	binary.LittleEndian.PutUint64(fields[16:], q.tail)
+	binary.LittleEndian.PutUint64(fields[24:], q.rightMarginIndex)
+	binary.LittleEndian.PutUint64(fields[32:], uint64(q.count))
+	binary.LittleEndian.PutUint64(fields[40:], q.generation)
+	if q.full {
+		fields[48] = 1
+	}
+	binary.LittleEndian.PutUint64(fields[49:], n)
+	if err := cw.write(header); err != nil {
+		return cw.n, err
+	}
+	checksum := make([]byte, persistHeaderChecksumSize)
+	binary.LittleEndian.PutUint32(checksum, crc32.ChecksumIEEE(header))
+	if err := cw.write(checksum); err != nil {
+		return cw.n, err
+	}
+	if withArray {
+		if err := cw.write(q.array[:n]); err != nil {
+			return cw.n, err
+		}
+	}
+	binary.LittleEndian.PutUint32(checksum, cw.crc.Sum32())
+	if err := cw.write(checksum); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// readLayout reads what writeLayout writes and replaces the layout of bytes-queue.
+// Bytes array is read from r if array is nil, otherwise it keeps entries already and must be of the written capacity.
+func (q *BytesQueue) readLayout(r io.Reader, magic string, array []byte) (int64, error) {
+	cr := &crcReader{r: r, crc: crc32.NewIEEE()}
+	header := make([]byte, persistHeaderSize)
+	if err := cr.read(header); err != nil {
+		return cr.n, err
+	}
+	if string(header[:len(magic)]) != magic {
+		return cr.n, ErrCorruptedQueue
+	}
+	if header[len(magic)] != persistVersion {
+		return cr.n, ErrUnsupportedVersion
+	}
+	checksum := make([]byte, persistHeaderChecksumSize)
+	if err := cr.read(checksum); err != nil {
+		return cr.n, err
+	}
+	if binary.LittleEndian.Uint32(checksum) != crc32.ChecksumIEEE(header) {
+		return cr.n, ErrCorruptedQueue
+	}
+
+	fields := header[len(magic)+1:]
+	capacity := binary.LittleEndian.Uint64(fields[0:])
+	head := binary.LittleEndian.Uint64(fields[8:])
+	tail := binary.LittleEndian.Uint64(fields[16:])
+	rightMarginIndex := binary.LittleEndian.Uint64(fields[24:])
+	count := binary.LittleEndian.Uint64(fields[32:])
+	generation := binary.LittleEndian.Uint64(fields[40:])
+	full := fields[48] == 1
+	n := binary.LittleEndian.Uint64(fields[49:])
+	if capacity > maxArraySize || n > capacity || count > n {
+		return cr.n, ErrCorruptedQueue
+	}
+	for _, index := range []uint64{head, tail, rightMarginIndex} {
+		if index < leftMarginIndex || index > n {
+			return cr.n, ErrCorruptedQueue
+		}
+	}
+	if q.maxCapacity > 0 && capacity > q.maxCapacity {
+		return cr.n, ErrFullQueue
+	}
+
+	allocated := array == nil
+	if allocated {
+		var err error
+		if array, err = q.storage.alloc(capacity); err != nil {
+			return cr.n, err
+		}
+		if err := cr.read(array[:n]); err != nil {
+			q.storage.free(array) // nolint
+			return cr.n, err
+		}
+	} else if uint64(len(array)) != capacity {
+		return cr.n, ErrCorruptedQueue
+	}
+	if err := readChecksum(cr); err != nil {
+		if allocated {
+			q.storage.free(array) // nolint
+		}
+		return cr.n, err
+	}
+
+	if allocated {
+		q.storage.free(q.array) // nolint
+	}
+	q.array = array
+	q.capacity = uint64(len(array))
+	q.head = head
+	q.tail = tail
+	q.rightMarginIndex = rightMarginIndex
+	q.count = int(count)
+	q.full = full
+	q.generation = generation
+	q.modCount++
+	return cr.n, nil
+}
+
+// readChecksum reads checksum and verifies it against everything read.
+func readChecksum(cr *crcReader) error {
+	sum := cr.crc.Sum32()
+	buf := make([]byte, 4)
+	if err := cr.read(buf); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(buf) != sum {
+		return ErrCorruptedQueue
+	}
+	return nil
+}
+
+// crcWriter writes to w and checksums everything written.
+type crcWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	n   int64
+}
+
+func (w *crcWriter) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.crc.Write(p[:n])
+	return err
+}
+
+// crcReader reads from r and checksums everything read.
+type crcReader struct {
+	r   io.Reader
+	crc hash.Hash32
+	n   int64
+}
+
+func (r *crcReader) read(p []byte) error {
+	n, err := io.ReadFull(r.r, p)
+	r.n += int64(n)
+	r.crc.Write(p[:n])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
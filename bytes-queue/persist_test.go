@@ -0,0 +1,114 @@
+package bytesqueue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/usherasnick/Useful-Go-Gadgets/fwriter"
+)
+
+func TestPersist(t *testing.T) {
+	q := NewBytesQueue(128, 128, false)
+	for i := 0; i < 3; i++ {
+		_, err := q.Push(bytes.Repeat([]byte{byte('a' + i)}, 38))
+		assert.Empty(t, err)
+	}
+	_, err := q.Pop()
+	assert.Empty(t, err)
+	// wrap around
+	index, err := q.PushEntry([]byte("hello"), 1, 2)
+	assert.Empty(t, err)
+
+	fn := filepath.Join(t.TempDir(), "queue.dat")
+	w, err := fwriter.NewSafeWriter(fn)
+	assert.Empty(t, err)
+	_, err = q.WriteTo(w)
+	assert.Empty(t, err)
+	assert.Empty(t, w.Commit())
+
+	f, err := os.Open(fn)
+	assert.Empty(t, err)
+	defer f.Close()
+	restored := NewBytesQueue(0, 0, false)
+	_, err = restored.ReadFrom(f)
+	assert.Empty(t, err)
+
+	assert.Equal(t, q.Capacity(), restored.Capacity())
+	assert.Equal(t, q.Len(), restored.Len())
+	data, timestamp, hash, err := restored.GetEntry(index)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	assert.Equal(t, int64(1), timestamp)
+	assert.Equal(t, uint64(2), hash)
+	for _, expected := range []string{"b", "c"} {
+		data, err = restored.Pop()
+		assert.Empty(t, err)
+		assert.Equal(t, bytes.Repeat([]byte(expected), 38), data)
+	}
+	_, err = restored.Push([]byte("world"))
+	assert.Empty(t, err)
+	data, err = restored.Pop()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), data)
+}
+
+func TestPersistCorrupted(t *testing.T) {
+	q := NewBytesQueue(64, 0, false)
+	_, err := q.Push([]byte("hello"))
+	assert.Empty(t, err)
+
+	var buf bytes.Buffer
+	n, err := q.WriteTo(&buf)
+	assert.Empty(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	image := buf.Bytes()
+
+	restored := NewBytesQueue(16, 0, false)
+	_, err = restored.Push([]byte("world"))
+	assert.Empty(t, err)
+
+	corrupted := append([]byte(nil), image...)
+	corrupted[persistHeaderSize+persistHeaderChecksumSize+2] ^= 0xff
+	_, err = restored.ReadFrom(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrCorruptedQueue, err)
+
+	corrupted = append([]byte(nil), image...)
+	corrupted[len(persistMagic)] = persistVersion + 1
+	_, err = restored.ReadFrom(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrUnsupportedVersion, err)
+
+	_, err = restored.ReadFrom(bytes.NewReader(image[:len(image)-1]))
+	assert.NotEmpty(t, err)
+
+	// a corrupted capacity is detected by checksum of header before allocating bytes array
+	corrupted = append([]byte(nil), image...)
+	corrupted[len(persistMagic)+1+7] ^= 0x7f
+	_, err = restored.ReadFrom(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrCorruptedQueue, err)
+
+	// a header with valid checksum but out of range fields is rejected as well
+	for _, corrupt := range []func(fields []byte){
+		func(fields []byte) { binary.LittleEndian.PutUint64(fields[0:], maxArraySize+1) },
+		func(fields []byte) { binary.LittleEndian.PutUint64(fields[8:], 0) },
+	} {
+		corrupted = append([]byte(nil), image...)
+		corrupt(corrupted[len(persistMagic)+1:])
+		binary.LittleEndian.PutUint32(corrupted[persistHeaderSize:], crc32.ChecksumIEEE(corrupted[:persistHeaderSize]))
+		_, err = restored.ReadFrom(bytes.NewReader(corrupted))
+		assert.Equal(t, ErrCorruptedQueue, err)
+	}
+
+	// restored is left untouched
+	data, err := restored.Peek()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("world"), data)
+
+	_, err = NewBytesQueue(0, 32, false).ReadFrom(bytes.NewReader(image))
+	assert.Equal(t, ErrFullQueue, err)
+}
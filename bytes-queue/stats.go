@@ -0,0 +1,79 @@
+package bytesqueue
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Stats is a snapshot of statistics of bytes-queue.
+type Stats struct {
+	// Capacity is number of allocated bytes
+	Capacity int
+	// UsedBytes is number of bytes occupied by entries and their headers
+	UsedBytes int
+	// WastedBytes is number of bytes after right margin index skipped by wrap-around
+	WastedBytes int
+	// Entries is number of entries kept
+	Entries int
+	// Reallocations is number of times bytes array has been reallocated, by growing or by Shrink
+	Reallocations int
+	// ReallocationTime is total time spent on reallocation
+	ReallocationTime time.Duration
+}
+
+// Logger receives events of bytes-queue, see SetLogger.
+type Logger interface {
+	// OnRealloc is called after bytes array is reallocated from oldCapacity to newCapacity bytes.
+	OnRealloc(oldCapacity int, newCapacity int, elapsed time.Duration)
+}
+
+// ZerologLogger logs events of bytes-queue by zerolog, which is used when verbose flag is set.
+type ZerologLogger struct {
+	Logger zerolog.Logger
+}
+
+// OnRealloc implements Logger.
+func (l ZerologLogger) OnRealloc(oldCapacity int, newCapacity int, elapsed time.Duration) {
+	l.Logger.Info().
+		Int("old_capacity", oldCapacity).
+		Int("new_capacity", newCapacity).
+		Dur("elapsed", elapsed).
+		Msg("reallocated bytes-queue")
+}
+
+// newVerboseLogger returns logger used when verbose flag is set, which logs by the global zerolog logger.
+func newVerboseLogger() Logger {
+	return ZerologLogger{Logger: log.Logger}
+}
+
+// SetLogger replaces logger of bytes-queue, nil disables logging.
+func (q *BytesQueue) SetLogger(logger Logger) {
+	q.logger = logger
+}
+
+// Stats returns statistics of bytes-queue.
+func (q *BytesQueue) Stats() Stats {
+	var wasted uint64
+	if q.count > 0 && q.tail <= q.head {
+		wasted = q.capacity - q.rightMarginIndex
+	}
+	return Stats{
+		Capacity:         int(q.capacity),
+		UsedBytes:        int(q.usedBytes()),
+		WastedBytes:      int(wasted),
+		Entries:          q.count,
+		Reallocations:    q.reallocs,
+		ReallocationTime: q.reallocTime,
+	}
+}
+
+func (q *BytesQueue) onRealloc(oldCapacity uint64, start time.Time) {
+	elapsed := time.Since(start)
+	q.reallocs++
+	q.reallocTime += elapsed
+	if q.logger != nil {
+		q.logger.OnRealloc(int(oldCapacity), int(q.capacity), elapsed)
+	}
+}
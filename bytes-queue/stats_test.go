@@ -0,0 +1,70 @@
+package bytesqueue
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type reallocLogger struct {
+	capacities [][2]int
+}
+
+func (l *reallocLogger) OnRealloc(oldCapacity int, newCapacity int, elapsed time.Duration) {
+	l.capacities = append(l.capacities, [2]int{oldCapacity, newCapacity})
+}
+
+func TestStats(t *testing.T) {
+	q := NewBytesQueue(64, 0, false)
+	logger := &reallocLogger{}
+	q.SetLogger(logger)
+
+	for i := 0; i < 3; i++ {
+		_, err := q.Push(make([]byte, 17))
+		assert.Empty(t, err)
+	}
+	_, err := q.Pop()
+	assert.Empty(t, err)
+	_, err = q.Pop()
+	assert.Empty(t, err)
+	// wrap around
+	_, err = q.Push(make([]byte, 17))
+	assert.Empty(t, err)
+	stats := q.Stats()
+	assert.Equal(t, Stats{Capacity: 64, UsedBytes: 42, WastedBytes: 0, Entries: 2}, stats)
+
+	// reallocation moves the older entry to the end of the new array
+	_, err = q.Push(make([]byte, 46))
+	assert.Empty(t, err)
+	stats = q.Stats()
+	assert.Equal(t, 128, stats.Capacity)
+	assert.Equal(t, 21+21+50, stats.UsedBytes)
+	assert.Equal(t, 0, stats.WastedBytes)
+	assert.Equal(t, 3, stats.Entries)
+	assert.Equal(t, 1, stats.Reallocations)
+	assert.Equal(t, [][2]int{{64, 128}}, logger.capacities)
+
+	// restored layout keeps the entries
+	var buf bytes.Buffer
+	_, err = q.WriteTo(&buf)
+	assert.Empty(t, err)
+	restored := NewBytesQueue(0, 0, false)
+	_, err = restored.ReadFrom(&buf)
+	assert.Empty(t, err)
+	assert.Equal(t, stats.UsedBytes, restored.Stats().UsedBytes)
+	assert.Equal(t, 3, restored.Stats().Entries)
+
+	for i := 0; i < 2; i++ {
+		_, err = q.Pop()
+		assert.Empty(t, err)
+	}
+	stats = q.Stats()
+	assert.Equal(t, 50, stats.UsedBytes)
+	assert.Equal(t, 1, stats.Entries)
+
+	assert.Empty(t, q.Shrink(100, func(int, int) {}))
+	assert.Equal(t, 2, q.Stats().Reallocations)
+	assert.Equal(t, [][2]int{{64, 128}, {128, 100}}, logger.capacities)
+}
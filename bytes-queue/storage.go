@@ -0,0 +1,87 @@
+package bytesqueue
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	ErrFixedCapacity = errors.New("Capacity of file-backed queue can not be changed")
+)
+
+// storage allocates bytes array of bytes-queue.
+type storage interface {
+	// alloc returns a new bytes array of at least size bytes.
+	alloc(size uint64) ([]byte, error)
+	// free releases bytes array returned by alloc.
+	free(array []byte) error
+}
+
+// heapStorage allocates bytes array in the Go heap, which is the default storage.
+type heapStorage struct{}
+
+func (heapStorage) alloc(size uint64) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+func (heapStorage) free([]byte) error {
+	return nil
+}
+
+// fileStorage maps a fixed size bytes array from file, see OpenFileBytesQueue.
+type fileStorage struct {
+	f  *os.File
+	fn string
+}
+
+func (*fileStorage) alloc(uint64) ([]byte, error) {
+	return nil, ErrFixedCapacity
+}
+
+func (*fileStorage) free([]byte) error {
+	return nil
+}
+
+// alignToPage rounds size up to a multiple of page size.
+func alignToPage(size uint64) uint64 {
+	pageSize := uint64(os.Getpagesize())
+	return (size + pageSize - 1) / pageSize * pageSize
+}
+
+// NewMmapBytesQueue is identical to NewBytesQueue, but keeps bytes array in anonymous memory mapping outside the Go heap.
+// Capacity and maxCapacity are rounded up to a multiple of page size.
+// Bytes array falls back to the Go heap on platforms without memory mapping.
+// Call Close to release the memory mapping once bytes-queue is no longer used.
+func NewMmapBytesQueue(capacity int, maxCapacity int, verbose bool) (*BytesQueue, error) {
+	array, err := mmapStorage{}.alloc(uint64(capacity))
+	if err != nil {
+		return nil, err
+	}
+	return newBytesQueue(mmapStorage{}, array, int(alignToPage(uint64(maxCapacity))), verbose), nil
+}
+
+// Close releases bytes array of bytes-queue, which must not be used any more.
+// File-backed bytes-queue is synced to disk before closing.
+func (q *BytesQueue) Close() error {
+	if fs, ok := q.storage.(*fileStorage); ok {
+		if err := q.Sync(); err != nil {
+			return err
+		}
+		if err := unmapFile(q.array); err != nil {
+			return err
+		}
+		q.release()
+		return fs.f.Close()
+	}
+
+	err := q.storage.free(q.array)
+	q.release()
+	return err
+}
+
+// release drops bytes array of bytes-queue, which looks like an empty queue of zero capacity afterwards.
+func (q *BytesQueue) release() {
+	q.Reset()
+	q.array = nil
+	q.capacity = 0
+}
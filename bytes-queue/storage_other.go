@@ -0,0 +1,41 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package bytesqueue
+
+import (
+	"io"
+	"os"
+)
+
+// mmapStorage falls back to page-aligned bytes array in the Go heap where memory mapping is not available.
+type mmapStorage struct{}
+
+func (mmapStorage) alloc(size uint64) ([]byte, error) {
+	return make([]byte, alignToPage(size)), nil
+}
+
+func (mmapStorage) free([]byte) error {
+	return nil
+}
+
+// mapFile reads the first size bytes of f into bytes array in the Go heap,
+// which is written back by flushFile.
+func mapFile(f *os.File, size uint64) ([]byte, error) {
+	array := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(f, 0, int64(size)), array); err != nil {
+		return nil, err
+	}
+	return array, nil
+}
+
+// unmapFile does nothing, bytes array returned by mapFile is collected by the Go runtime.
+func unmapFile([]byte) error {
+	return nil
+}
+
+// flushFile writes bytes array returned by mapFile back to f.
+func flushFile(f *os.File, array []byte) error {
+	_, err := f.WriteAt(array, 0)
+	return err
+}
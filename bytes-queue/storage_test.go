@@ -0,0 +1,74 @@
+package bytesqueue
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMmapBytesQueue(t *testing.T) {
+	q, err := NewMmapBytesQueue(100, 0, false)
+	assert.Empty(t, err)
+	assert.Equal(t, os.Getpagesize(), q.Capacity())
+
+	var indexes []int
+	for i := 0; i < 1000; i++ {
+		index, err := q.Push([]byte("0123456789"))
+		assert.Empty(t, err)
+		indexes = append(indexes, index)
+	}
+	assert.Equal(t, 0, q.Capacity()%os.Getpagesize())
+	for _, index := range indexes {
+		data, err := q.Get(index)
+		assert.Empty(t, err)
+		assert.Equal(t, []byte("0123456789"), data)
+	}
+
+	q.Reset()
+	assert.Empty(t, q.Shrink(1, nil))
+	assert.Equal(t, os.Getpagesize(), q.Capacity())
+	assert.Empty(t, q.Close())
+}
+
+func TestFileBytesQueue(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "queue.dat")
+	q, err := OpenFileBytesQueue(fn, 100, false)
+	assert.Empty(t, err)
+	assert.Equal(t, os.Getpagesize(), q.Capacity())
+
+	var indexes []int
+	for {
+		index, err := q.PushEntry([]byte("0123456789"), 1, 2)
+		if err != nil {
+			assert.Equal(t, ErrFullQueue, err)
+			break
+		}
+		indexes = append(indexes, index)
+	}
+	assert.Equal(t, ErrFixedCapacity, q.Shrink(1, func(int, int) {}))
+	_, err = q.Pop()
+	assert.Empty(t, err)
+	assert.Empty(t, q.Close())
+
+	q, err = OpenFileBytesQueue(fn, 0, false)
+	assert.Empty(t, err)
+	assert.Equal(t, os.Getpagesize(), q.Capacity())
+	assert.Equal(t, len(indexes)-1, q.Len())
+	_, err = q.Get(indexes[0])
+	assert.Equal(t, ErrEntryNotFound, err)
+	for _, index := range indexes[1:] {
+		data, timestamp, hash, err := q.GetEntry(index)
+		assert.Empty(t, err)
+		assert.Equal(t, []byte("0123456789"), data)
+		assert.Equal(t, int64(1), timestamp)
+		assert.Equal(t, uint64(2), hash)
+	}
+	assert.Empty(t, q.Close())
+
+	assert.Empty(t, os.WriteFile(fn+fileLayoutSuffix, bytes.Repeat([]byte("x"), 100), 0644))
+	_, err = OpenFileBytesQueue(fn, 0, false)
+	assert.Equal(t, ErrCorruptedQueue, err)
+}
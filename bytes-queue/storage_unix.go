@@ -0,0 +1,42 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package bytesqueue
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapStorage allocates bytes array in anonymous memory mapping of page-aligned size outside the Go heap.
+type mmapStorage struct{}
+
+func (mmapStorage) alloc(size uint64) ([]byte, error) {
+	size = alignToPage(size)
+	if size == 0 {
+		return []byte{}, nil
+	}
+	return syscall.Mmap(-1, 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+func (mmapStorage) free(array []byte) error {
+	if cap(array) == 0 {
+		return nil
+	}
+	return syscall.Munmap(array)
+}
+
+// mapFile returns a shared memory mapping of the first size bytes of f.
+func mapFile(f *os.File, size uint64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+// unmapFile releases memory mapping returned by mapFile.
+func unmapFile(array []byte) error {
+	return syscall.Munmap(array)
+}
+
+// flushFile does nothing, since fsync of f also writes back pages dirtied through the shared memory mapping.
+func flushFile(*os.File, []byte) error {
+	return nil
+}
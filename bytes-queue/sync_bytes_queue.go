@@ -0,0 +1,405 @@
+package bytesqueue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueClosed = errors.New("Queue has been closed")
+)
+
+// SyncBytesQueue is a thread-safe wrapper of BytesQueue.
+// Pop and PopCtx wait for entries, PushCtx waits for free space when maximum queue size limit is reached.
+// Since other goroutines may overwrite the internal array at any time, every returned entry is a copy.
+// Call Close to release the underlying bytes-queue once it is no longer used.
+type SyncBytesQueue struct {
+	mu sync.Mutex
+
+	q      *BytesQueue
+	closed bool
+	// changed is closed and replaced on every mutation to wake up all waiters
+	changed chan struct{}
+	// quit is closed by Close to stop janitor
+	quit    chan struct{}
+	janitor bool
+}
+
+// NewSyncBytesQueue initializes a new thread-safe bytes-queue.
+func NewSyncBytesQueue(capacity int, maxCapacity int, verbose bool) *SyncBytesQueue {
+	return WrapBytesQueue(NewBytesQueue(capacity, maxCapacity, verbose))
+}
+
+// WrapBytesQueue makes q thread-safe, e.g. one created by NewMmapBytesQueue or OpenFileBytesQueue.
+// q must not be used directly any more.
+func WrapBytesQueue(q *BytesQueue) *SyncBytesQueue {
+	return &SyncBytesQueue{
+		q:       q,
+		changed: make(chan struct{}),
+		quit:    make(chan struct{}),
+	}
+}
+
+// notify wakes up all waiters, caller must hold the lock.
+func (q *SyncBytesQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Push copies entry at the end of bytes-queue without waiting.
+// Returns ErrFullQueue if maximum queue size limit is reached.
+func (q *SyncBytesQueue) Push(data []byte) (int, error) {
+	return q.push(data, false, 0, 0)
+}
+
+// PushEntry copies entry with timestamp and hash at the end of bytes-queue without waiting.
+func (q *SyncBytesQueue) PushEntry(data []byte, timestamp int64, hash uint64) (int, error) {
+	return q.push(data, true, timestamp, hash)
+}
+
+func (q *SyncBytesQueue) push(data []byte, withMeta bool, timestamp int64, hash uint64) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return -1, ErrQueueClosed
+	}
+	index, err := q.q.pushEntry(data, withMeta, timestamp, hash)
+	if err != nil {
+		return -1, err
+	}
+	q.notify()
+	return index, nil
+}
+
+// PushCtx copies entry at the end of bytes-queue.
+// If maximum queue size limit is reached, it waits until other goroutines pop enough entries, ctx is done or queue is closed.
+// Returns ErrFullQueue at once if the entry does not fit even in an empty queue.
+func (q *SyncBytesQueue) PushCtx(ctx context.Context, data []byte) (int, error) {
+	return q.pushCtx(ctx, data, false, 0, 0)
+}
+
+// PushEntryCtx is identical to PushCtx, but keeps timestamp and hash in header of entry.
+func (q *SyncBytesQueue) PushEntryCtx(ctx context.Context, data []byte, timestamp int64, hash uint64) (int, error) {
+	return q.pushCtx(ctx, data, true, timestamp, hash)
+}
+
+func (q *SyncBytesQueue) pushCtx(ctx context.Context, data []byte, withMeta bool, timestamp int64, hash uint64) (int, error) {
+	q.mu.Lock()
+	for {
+		if q.closed {
+			q.mu.Unlock()
+			return -1, ErrQueueClosed
+		}
+		index, err := q.q.pushEntry(data, withMeta, timestamp, hash)
+		if err == nil {
+			q.notify()
+			q.mu.Unlock()
+			return index, nil
+		}
+		if err != ErrFullQueue || q.q.Len() == 0 || !q.fits(len(data), withMeta) {
+			q.mu.Unlock()
+			return -1, err
+		}
+
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		}
+		q.mu.Lock()
+	}
+}
+
+// fits reports whether entry of dataLen bytes can be pushed into an empty bytes-queue.
+func (q *SyncBytesQueue) fits(dataLen int, withMeta bool) bool {
+	need := uint64(dataLen) + getHeaderEntrySize(uint64(dataLen), withMeta)
+	return q.q.maxCapacity == 0 || leftMarginIndex+need < q.q.maxCapacity
+}
+
+// PushBatch copies entries at the end of bytes-queue without waiting, see BytesQueue.PushBatch.
+func (q *SyncBytesQueue) PushBatch(entries [][]byte) ([]int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	indexes, err := q.q.PushBatch(entries)
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return indexes, nil
+}
+
+// PopInto copies the oldest entry into dst without waiting, see BytesQueue.PopInto.
+func (q *SyncBytesQueue) PopInto(dst []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+	n, err := q.q.PopInto(dst)
+	if err != nil {
+		return 0, err
+	}
+	q.notify()
+	return n, nil
+}
+
+// Pop reads a copy of the oldest entry from bytes-queue without waiting.
+func (q *SyncBytesQueue) Pop() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pop()
+}
+
+// PopCtx reads a copy of the oldest entry from bytes-queue.
+// If bytes-queue is empty, it waits until other goroutines push an entry, ctx is done or queue is closed.
+func (q *SyncBytesQueue) PopCtx(ctx context.Context) ([]byte, error) {
+	q.mu.Lock()
+	for {
+		data, err := q.pop()
+		if err != ErrEmptyQueue {
+			q.mu.Unlock()
+			return data, err
+		}
+
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		q.mu.Lock()
+	}
+}
+
+func (q *SyncBytesQueue) pop() ([]byte, error) {
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	data, err := q.q.PopView()
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return append([]byte(nil), data...), nil
+}
+
+// Peek reads a copy of the oldest entry from bytes-queue without moving head pointer.
+func (q *SyncBytesQueue) Peek() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	data, err := q.q.Peek()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// PeekEntry reads a copy of the oldest entry with its timestamp and hash from bytes-queue without moving head pointer.
+func (q *SyncBytesQueue) PeekEntry() ([]byte, int64, uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, 0, 0, ErrQueueClosed
+	}
+	data, timestamp, hash, err := q.q.PeekEntry()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return append([]byte(nil), data...), timestamp, hash, nil
+}
+
+// Get reads a copy of entry at index from bytes-queue.
+func (q *SyncBytesQueue) Get(index int) ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	data, err := q.q.Get(index)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// GetEntry reads a copy of entry at index with its timestamp and hash from bytes-queue.
+func (q *SyncBytesQueue) GetEntry(index int) ([]byte, int64, uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, 0, 0, ErrQueueClosed
+	}
+	data, timestamp, hash, err := q.q.GetEntry(index)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return append([]byte(nil), data...), timestamp, hash, nil
+}
+
+// EvictOlderThan pops entries from the head of bytes-queue while they are older than d,
+// and calls onEvict (if not nil) for each one while holding the lock, so onEvict must not call methods of bytes-queue.
+// Returns number of evicted entries.
+func (q *SyncBytesQueue) EvictOlderThan(d time.Duration, onEvict func(data []byte)) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	evicted := q.q.EvictOlderThan(d, onEvict)
+	if evicted > 0 {
+		q.notify()
+	}
+	return evicted
+}
+
+// StartJanitor starts a background goroutine which calls EvictOlderThan(ttl, onEvict) every interval until bytes-queue is closed.
+// Only the first call takes effect.
+func (q *SyncBytesQueue) StartJanitor(interval time.Duration, ttl time.Duration, onEvict func(data []byte)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.janitor || q.closed {
+		return
+	}
+	q.janitor = true
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				q.EvictOlderThan(ttl, onEvict)
+			case <-q.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Snapshot copies out entries of bytes-queue into a compacted byte image, see BytesQueue.Snapshot.
+func (q *SyncBytesQueue) Snapshot() []byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.q.Snapshot()
+}
+
+// WriteTo writes bytes-queue to w, see BytesQueue.WriteTo.
+func (q *SyncBytesQueue) WriteTo(w io.Writer) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+	return q.q.WriteTo(w)
+}
+
+// ReadFrom replaces entries of bytes-queue with the ones read from r, see BytesQueue.ReadFrom.
+func (q *SyncBytesQueue) ReadFrom(r io.Reader) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+	n, err := q.q.ReadFrom(r)
+	if err == nil {
+		q.notify()
+	}
+	return n, err
+}
+
+// Shrink reallocates bytes-queue down to capacity, see BytesQueue.Shrink.
+// onRemap is called while holding the lock, so it must not call methods of bytes-queue.
+func (q *SyncBytesQueue) Shrink(capacity int, onRemap func(oldIndex int, newIndex int)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	return q.q.Shrink(capacity, onRemap)
+}
+
+// Sync flushes entries of file-backed bytes-queue to disk, see BytesQueue.Sync.
+func (q *SyncBytesQueue) Sync() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	return q.q.Sync()
+}
+
+// Reset removes all entries from bytes-queue and wakes up all pushers waiting for space.
+func (q *SyncBytesQueue) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.q.Reset()
+	q.notify()
+}
+
+// Stats returns statistics of bytes-queue.
+func (q *SyncBytesQueue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.q.Stats()
+}
+
+// SetLogger replaces logger of bytes-queue, nil disables logging.
+// Logger is called while holding the lock, so it must not call methods of bytes-queue.
+func (q *SyncBytesQueue) SetLogger(logger Logger) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.q.SetLogger(logger)
+}
+
+// Capacity returns number of allocated bytes for bytes-queue.
+func (q *SyncBytesQueue) Capacity() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.q.Capacity()
+}
+
+// Len returns number of entries kept in bytes-queue.
+func (q *SyncBytesQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.q.Len()
+}
+
+// Close closes bytes-queue, stops janitor and wakes up all waiters with ErrQueueClosed,
+// then releases the underlying bytes-queue, see BytesQueue.Close, so file-backed bytes-queue is synced to disk.
+// Entries left in bytes-queue are dropped, further calls fail with ErrQueueClosed.
+func (q *SyncBytesQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.quit)
+	q.notify()
+	return q.q.Close()
+}
@@ -0,0 +1,174 @@
+package bytesqueue
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncBytesQueueConcurrent(t *testing.T) {
+	q := NewSyncBytesQueue(64, 0, false)
+
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				_, err := q.Push([]byte(fmt.Sprintf("%d-%06d", p, i)))
+				assert.Empty(t, err)
+			}
+		}(p)
+	}
+
+	got := make(chan []byte, 4000)
+	var cwg sync.WaitGroup
+	for c := 0; c < 4; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for {
+				data, err := q.PopCtx(context.Background())
+				if err == ErrQueueClosed {
+					return
+				}
+				assert.Empty(t, err)
+				got <- data
+			}
+		}()
+	}
+
+	wg.Wait()
+	seen := make(map[string]bool)
+	for i := 0; i < 4000; i++ {
+		seen[string(<-got)] = true
+	}
+	assert.Empty(t, q.Close())
+	cwg.Wait()
+	assert.Equal(t, 4000, len(seen))
+	_, err := q.Push([]byte("closed"))
+	assert.Equal(t, ErrQueueClosed, err)
+}
+
+func TestSyncBytesQueuePushCtx(t *testing.T) {
+	q := NewSyncBytesQueue(32, 32, false)
+	for {
+		if _, err := q.Push([]byte("0123456")); err != nil {
+			assert.Equal(t, ErrFullQueue, err)
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := q.PushCtx(ctx, []byte("0123456"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the entry can never fit in the queue
+	_, err = q.PushCtx(context.Background(), make([]byte, 64))
+	assert.Equal(t, ErrFullQueue, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := q.PushCtx(context.Background(), []byte("abcdefg"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	data, err := q.Pop()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("0123456"), data)
+	assert.Empty(t, <-done)
+
+	go func() {
+		_, err := q.PushCtx(context.Background(), []byte("hijklmn"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, q.Close())
+	assert.Equal(t, ErrQueueClosed, <-done)
+}
+
+func TestSyncBytesQueuePopCtx(t *testing.T) {
+	q := NewSyncBytesQueue(32, 0, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := q.PopCtx(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	done := make(chan []byte)
+	go func() {
+		data, err := q.PopCtx(context.Background())
+		assert.Empty(t, err)
+		done <- data
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_, err = q.Push([]byte("hello"))
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), <-done)
+
+	_, err = q.Push([]byte("world"))
+	assert.Empty(t, err)
+	assert.Empty(t, q.Close())
+	_, err = q.PopCtx(context.Background())
+	assert.Equal(t, ErrQueueClosed, err)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestSyncBytesQueueJanitor(t *testing.T) {
+	q := NewSyncBytesQueue(64, 0, false)
+
+	evicted := make(chan []byte, 2)
+	q.StartJanitor(5*time.Millisecond, 20*time.Millisecond, func(data []byte) {
+		evicted <- append([]byte(nil), data...)
+	})
+	_, err := q.PushEntry([]byte("hello"), time.Now().UnixNano(), 0)
+	assert.Empty(t, err)
+	_, err = q.PushEntry([]byte("world"), time.Now().Add(time.Hour).UnixNano(), 0)
+	assert.Empty(t, err)
+
+	select {
+	case data := <-evicted:
+		assert.Equal(t, []byte("hello"), data)
+	case <-time.After(time.Second):
+		t.Fatal("entry is not evicted")
+	}
+	assert.Equal(t, 1, q.Len())
+	assert.Empty(t, q.Close())
+}
+
+func TestSyncBytesQueueFileBacked(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "queue.dat")
+	fq, err := OpenFileBytesQueue(fn, 100, false)
+	assert.Empty(t, err)
+	q := WrapBytesQueue(fq)
+
+	index, err := q.Push([]byte("hello"))
+	assert.Empty(t, err)
+	assert.Empty(t, q.Sync())
+	_, err = q.Push([]byte("world"))
+	assert.Empty(t, err)
+	assert.Empty(t, q.Close())
+	assert.Empty(t, q.Close())
+	assert.Equal(t, ErrQueueClosed, q.Sync())
+	_, err = q.Get(index)
+	assert.Equal(t, ErrQueueClosed, err)
+
+	// Close syncs the entries pushed after the last Sync
+	fq, err = OpenFileBytesQueue(fn, 0, false)
+	assert.Empty(t, err)
+	q = WrapBytesQueue(fq)
+	assert.Equal(t, 2, q.Len())
+	data, err := q.Get(index)
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	data, err = q.Pop()
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	assert.Empty(t, q.Close())
+}
@@ -53,6 +53,11 @@ func (w *SafeWriter) WriteString(content string) (int, error) {
 	return w.writer.WriteString(content)
 }
 
+// Sync 将已写入的数据刷到硬盘, 但不提交.
+func (w *SafeWriter) Sync() error {
+	return w.writer.Sync()
+}
+
 // Commit 持久化内存数据到硬盘.
 func (w *SafeWriter) Commit() error {
 	defer w.exit()